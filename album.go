@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// discFolderPattern matches folders holding one disc of a multi-disc album, ex. CD1, Disc 2, disk_3
+var discFolderPattern = regexp.MustCompile(`(?i)^(cd|dis[ck])[ _.-]*\d+$`)
+
+// songAlbumKey returns the identity of the album of a song.
+// This is its MusicBrainz release id if tagged, so that releases of the same name are kept apart.
+// Otherwise it is the album artist, album, year and folder of the song, see songAlbumFolder.
+// Songs without an album artist are not keyed by artist, so that compilations are not split by artist.
+func songAlbumKey(song *Song) string {
+	if len(song.MusicBrainzAlbumID) > 0 {
+		return "mbid:" + strings.ToLower(song.MusicBrainzAlbumID)
+	}
+	year := ""
+	if date, ok := parseDate(song.Date); ok {
+		year = strconv.Itoa(date.Year)
+	}
+	return strings.Join([]string{
+		foldText(song.AlbumArtist), strings.ToLower(song.Album), year, songAlbumFolder(song),
+	}, "\x00")
+}
+
+// songAlbumFolder returns the folder of a song, or its parent if the folder is a disc folder, ex. CD2.
+func songAlbumFolder(song *Song) string {
+	dir := filepath.Dir(song.Path)
+	if discFolderPattern.MatchString(filepath.Base(dir)) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// groupAlbums groups the songs of a library by album, see songAlbumKey.
+// Songs of each album are sorted by path.
+func groupAlbums(lib Library) map[string][]*Song {
+	result := make(map[string][]*Song)
+	forbidErr(lib.songs(func(song *Song) error {
+		key := songAlbumKey(song)
+		result[key] = append(result[key], song)
+		return nil
+	}))
+	for _, songs := range result {
+		sort.Slice(songs, func(i, j int) bool {
+			return songs[i].Path < songs[j].Path
+		})
+	}
+	return result
+}
+
+// albumArtists returns the album artists of an album, as tagged on most of its songs,
+// so that songs with other spellings, ex. on another disc, are not split into another album.
+// If no song has an album artist, the first artist of every song is returned, and any other artist of every song,
+// so that the featured artists of some songs are not album artists.
+func albumArtists(songs []*Song) []string {
+	var artists []string
+	songCounts := make(map[string]int)
+	for _, song := range songs {
+		if len(song.AlbumArtist) > 0 {
+			artists = nil
+			break
+		}
+		songArtists := songAlbumArtists(song)
+		artists = append(artists, songArtists[0])
+		for _, artist := range splitTagValues(songArtists, nil) {
+			songCounts[artistKey(artist)]++
+		}
+	}
+	if len(artists) > 0 {
+		for _, song := range songs {
+			for _, artist := range songAlbumArtists(song)[1:] {
+				if songCounts[artistKey(artist)] == len(songs) {
+					artists = append(artists, artist)
+				}
+			}
+		}
+		return splitTagValues(artists, nil)
+	}
+
+	counts := make(map[string]int)
+	names := make(map[string][]string) // first spelling of each
+	bestKey := ""
+	for _, song := range songs {
+		artists := songAlbumArtists(song)
+		var keys []string
+		for _, artist := range artists {
+			keys = append(keys, artistKey(artist))
+		}
+		key := strings.Join(keys, "\x00")
+		if _, ok := names[key]; !ok {
+			names[key] = artists
+		}
+		counts[key]++
+		if counts[key] > counts[bestKey] {
+			bestKey = key
+		}
+	}
+	return names[bestKey]
+}
+
+// minCompilationArtists is the least number of artists on an album without album artist for it to be a compilation.
+const minCompilationArtists = 3
+
+// isCompilation returns true if any song of an album is flagged as part of a compilation, or if no song
+// has an album artist and there are many artists, none of which is the (first) artist of half the songs or more.
+func isCompilation(songs []*Song) bool {
+	for _, song := range songs {
+		if song.Compilation {
+			return true
+		}
+	}
+	counts := make(map[string]int)
+	for _, song := range songs {
+		if len(song.AlbumArtist) > 0 {
+			return false
+		}
+		counts[strings.ToLower(songAlbumArtists(song)[0])]++
+	}
+	if len(counts) < minCompilationArtists {
+		return false
+	}
+	for _, count := range counts {
+		if count*2 >= len(songs) {
+			return false
+		}
+	}
+	return true
+}
+
+// ambiguousAlbum is an album whose songs may belong to more than one album.
+type ambiguousAlbum struct {
+	Name    string   `json:"name"`
+	Reasons []string `json:"reasons"`
+	Paths   []string `json:"paths"`
+}
+
+// ambiguousAlbums finds albums merged from songs that disagree on album name, album artist or folder,
+// or that repeat a disc and track number, sorted by name.
+func ambiguousAlbums(lib Library) []ambiguousAlbum {
+	var result []ambiguousAlbum
+	for _, songs := range groupAlbums(lib) {
+		names := make(map[string]bool)
+		artists := make(map[string]bool)
+		folders := make(map[string]bool)
+		tracks := make(map[[2]int]bool)
+		repeatedTrack := false
+		for _, song := range songs {
+			names[song.Album] = true
+			artists[foldText(song.AlbumArtist)] = true
+			folders[songAlbumFolder(song)] = true
+			if song.Track > 0 {
+				track := [2]int{song.Disc, song.Track}
+				repeatedTrack = repeatedTrack || tracks[track]
+				tracks[track] = true
+			}
+		}
+		var reasons []string
+		if len(names) > 1 {
+			reasons = append(reasons, fmt.Sprintf("%v album names", len(names)))
+		}
+		if len(artists) > 1 {
+			reasons = append(reasons, fmt.Sprintf("%v album artists", len(artists)))
+		}
+		if len(folders) > 1 {
+			reasons = append(reasons, fmt.Sprintf("%v folders", len(folders)))
+		}
+		if repeatedTrack {
+			reasons = append(reasons, "repeated disc and track numbers")
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		album := ambiguousAlbum{Name: songs[0].Album, Reasons: reasons}
+		for _, song := range songs {
+			album.Paths = append(album.Paths, song.Path)
+		}
+		result = append(result, album)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Paths[0] < result[j].Paths[0]
+	})
+	return result
+}
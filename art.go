@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// name of the normalized cover art written to each album folder of the mobile library
+	coverFile = "cover.jpg"
+	// default jpeg quality of resized art
+	defaultArtQuality = 85
+)
+
+// names of folder images used as album covers, in order of preference
+var coverNames = []string{"cover", "folder", "front", "album"}
+
+// resizeArt downscales image data so neither dimension is over maxSize, keeping the image format.
+// The original data is returned if the image is already small enough, or if maxSize is zero or less.
+func resizeArt(data []byte, maxSize, quality int) ([]byte, error) {
+	return scaleArt(data, maxSize, quality, false)
+}
+
+// coverArt is like resizeArt, but always returns jpeg data.
+func coverArt(data []byte, maxSize, quality int) ([]byte, error) {
+	return scaleArt(data, maxSize, quality, true)
+}
+
+func scaleArt(data []byte, maxSize, quality int, forceJpeg bool) ([]byte, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	small := maxSize <= 0 || (bounds.Dx() <= maxSize && bounds.Dy() <= maxSize)
+	if small && (format == "jpeg" || !forceJpeg) {
+		return data, nil
+	}
+	if !small {
+		img = downscale(img, maxSize)
+	}
+	var out bytes.Buffer
+	if format == "png" && !forceJpeg {
+		err = png.Encode(&out, img)
+	} else {
+		err = jpeg.Encode(&out, flatten(img), &jpeg.Options{Quality: quality})
+	}
+	return out.Bytes(), err
+}
+
+// downscale resizes an image so neither dimension is over maxSize, keeping the aspect ratio.
+// Each pixel is the average of the source pixels it covers.
+func downscale(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := maxSize, maxSize
+	if srcWidth > srcHeight {
+		height = srcHeight * maxSize / srcWidth
+	} else {
+		width = srcWidth * maxSize / srcHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + (y+1)*srcHeight/height
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + (x+1)*srcWidth/width
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(a / count),
+			})
+		}
+	}
+	return dst
+}
+
+// flatten draws an image over a white background, as jpeg has no transparency.
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	draw.Draw(result, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(result, bounds, img, bounds.Min, draw.Over)
+	return result
+}
+
+// resizedArtFile returns a mobile file generator that resizes the art file at path.
+func resizedArtFile(path string, maxSize, quality int) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return resizeArt(data, maxSize, quality)
+	}
+}
+
+// folderCovers returns the folder image most likely to be the album cover in each folder with images.
+func folderCovers(artFiles []*mobileFile) map[string]*mobileFile {
+	folderArt := make(map[string][]*mobileFile)
+	for _, mf := range artFiles {
+		dir := filepath.Dir(mf.rootPath)
+		folderArt[dir] = append(folderArt[dir], mf)
+	}
+	result := make(map[string]*mobileFile, len(folderArt))
+	for dir, images := range folderArt {
+		result[dir] = findCoverImage(images)
+	}
+	return result
+}
+
+// albumCovers returns a normalized cover for each album, made from a folder image in the album folder,
+// or if there is none, the art of the first song in the album that has any.
+func albumCovers(args mobileSyncArgs, albums []mobileAlbum, covers map[string]*mobileFile) []*mobileFile {
+	var result []*mobileFile
+	for _, album := range albums {
+		var (
+			source  func() ([]byte, error)
+			version string
+		)
+		if folderImage, ok := covers[album.dir]; ok {
+			path := args.root + folderImage.rootPath
+			source = func() ([]byte, error) {
+				return ioutil.ReadFile(path)
+			}
+			version = versionOf(folderImage.rootPath, folderImage.size, folderImage.modTime, args.artSize, args.artQuality)
+		} else if art, artFile := findSongArt(args.lib, album.songs); art != nil {
+			source = func() ([]byte, error) {
+				return art.Data, nil
+			}
+			version = versionOf(artFile, args.artSize, args.artQuality)
+		} else {
+			continue
+		}
+		result = append(result, &mobileFile{
+			rootPath: filepath.Join(album.dir, coverFile),
+			fileType: "JPG",
+			version:  version,
+			generate: func() ([]byte, error) {
+				data, err := source()
+				if err != nil {
+					return nil, err
+				}
+				return coverArt(data, args.artSize, args.artQuality)
+			},
+		})
+	}
+	return result
+}
+
+// findCoverImage returns the folder image most likely to be the album cover, or nil if there are no images.
+func findCoverImage(images []*mobileFile) *mobileFile {
+	for _, name := range coverNames {
+		for _, mf := range images {
+			base := filepath.Base(mf.rootPath)
+			if strings.EqualFold(base[:len(base)-len(filepath.Ext(base))], name) {
+				return mf
+			}
+		}
+	}
+	if len(images) > 0 {
+		return images[0]
+	}
+	return nil
+}
+
+// findSongArt returns the art of the first song that has any, and its art file, or nil.
+func findSongArt(lib Library, songs []*Song) (*Art, string) {
+	for _, song := range songs {
+		if len(song.Art) == 0 {
+			continue
+		}
+		if hash, err := extractPicHash(song.Art); err == nil {
+			if art := lib.findArt(hash); art != nil {
+				return art, song.Art
+			}
+		}
+	}
+	return nil, ""
+}
+
+// selectedWithoutCovers removes root library art files that would be replaced by a normalized album cover.
+func selectedWithoutCovers(selected []*mobileFile, albums []mobileAlbum) []*mobileFile {
+	covers := make(map[string]struct{})
+	for _, album := range albums {
+		covers[strings.ToLower(filepath.Join(album.dir, coverFile))] = struct{}{}
+	}
+	var result []*mobileFile
+	for _, mf := range selected {
+		if _, ok := covers[strings.ToLower(mf.rootPath)]; ok && mf.song == nil && mf.generate == nil {
+			continue
+		}
+		result = append(result, mf)
+	}
+	return result
+}
+
+// pictureCache resizes art for embedding in opus files, keeping the last picture
+// as songs of the same album are usually encoded one after another.
+type pictureCache struct {
+	args     mobileSyncArgs
+	covers   map[string]*mobileFile
+	lock     sync.Mutex
+	lastArt  string
+	lastData []byte
+}
+
+func newPictureCache(args mobileSyncArgs, covers map[string]*mobileFile) *pictureCache {
+	return &pictureCache{args: args, covers: covers}
+}
+
+// source returns the art to embed for a song file, as a key identifying it, a version that changes
+// whenever its content does, and a function reading its data. The key is empty if there is no art.
+// The song art is used, or if it has none, the cover image in its folder.
+func (p *pictureCache) source(mf *mobileFile) (string, string, func() ([]byte, error)) {
+	if art, artFile := findSongArt(p.args.lib, []*Song{mf.song}); art != nil {
+		return artFile, artFile, func() ([]byte, error) {
+			return art.Data, nil
+		}
+	}
+	if folderImage, ok := p.covers[filepath.Dir(mf.rootPath)]; ok {
+		version := fmt.Sprint(folderImage.rootPath, folderImage.size, folderImage.modTime)
+		return folderImage.rootPath, version, func() ([]byte, error) {
+			return ioutil.ReadFile(p.args.root + folderImage.rootPath)
+		}
+	}
+	return "", "", nil
+}
+
+// version returns the version of the art embedded in a song file, including the art settings,
+// or an empty string if no art is embedded.
+func (p *pictureCache) version(mf *mobileFile) string {
+	if !p.args.embedArt || mf.fileType != string(tag.FLAC) {
+		return ""
+	}
+	key, version, _ := p.source(mf)
+	if len(key) == 0 {
+		return ""
+	}
+	return fmt.Sprint(version, p.args.artSize, p.args.artQuality)
+}
+
+// get returns the resized front cover to embed for a song file, or nil if art is not embedded.
+func (p *pictureCache) get(mf *mobileFile) []byte {
+	if !p.args.embedArt {
+		return nil
+	}
+	key, _, source := p.source(mf)
+	if len(key) == 0 {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key == p.lastArt {
+		return p.lastData
+	}
+	data, err := source()
+	if err == nil {
+		data, err = coverArt(data, p.args.artSize, p.args.artQuality)
+	}
+	if err != nil {
+		p.args.logger.Printf("failed to resize art %q for embedding: %v", key, err)
+		data = nil
+	}
+	p.lastArt, p.lastData = key, data
+	return data
+}
@@ -1,53 +1,92 @@
 package main
 
 import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"golang.org/x/text/language"
 	"log"
 	"sort"
 	"time"
 )
 
+const (
+	// default album artist of compilations
+	defaultVariousArtists = "Various Artists"
+	// name of the last child of a discography, with the songs of an artist on compilations
+	appearsOnName = "Appears On"
+)
+
+// collectionArgs are the options of organizing a library into collections.
+type collectionArgs struct {
+	variousArtists string       // album artist of compilations
+	locale         language.Tag // collation of artist and album names
+	articles       []string     // leading articles ignored when sorting names, see nameCollator
+	aliases        *artistAliases
+	formats        []tag.FileType // most preferred first, to list one version of albums in several formats
+}
+
 // TODO: Support more sorting and grouping options by implementing a query dsl like foobar2000
 // TODO: Support max depth
-// ArtistAlbumDateCollection builds a collection by artist/album, sorting albums by date.
-func ArtistAblumDateCollection(lib Library, logger *log.Logger) Collection {
+// ArtistAlbumDateCollection builds a collection by artist/album, sorting albums by original release date, see songSortDate.
+// Artists are sorted by their sort name if tagged, else by name, see nameCollator.
+// Compilations are listed under the various artists name, and the songs of each artist on compilations
+// are listed together in an appears on album, after the albums of the artist.
+// Albums in more than one format are listed once, in the preferred format, with the others as alternates,
+// see preferFormats.
+func ArtistAblumDateCollection(lib Library, args collectionArgs, logger *log.Logger) Collection {
 	start := time.Now()
+	albums := groupAlbums(lib)
+	alternates := preferFormats(albums, args)
 	aa := make(artistAlbum)
-	forbidErr(aa.addAll(lib))
+	appearsOn := make(artistAlbum)
+	aa.addAll(albums, args, appearsOn)
 
-	sortedArtists, sortedAlbums := sortArtistAlbumDate(aa)
+	collator := newNameCollator(args.locale, args.articles)
+	sortedAlbums := sortArtistAlbumDate(aa, collator)
+	sortedAppearsOn := sortArtistAlbumDate(appearsOn, collator)
 
 	result := Collection{
 		Name: "ArtistAlbumDateCollection",
 		lib:  lib,
 	}
-	for _, artist := range sortedArtists {
+	var artistKeys []string
+	for artist := range aa {
+		artistKeys = append(artistKeys, artist)
+	}
+	sort.Strings(artistKeys)
+	var sortNames []string
+	for _, artist := range artistKeys {
 		discography := Collection{}
 		for _, album := range sortedAlbums[artist] {
 			albumSongs := aa[artist][album]
-			var metas []string
-			for _, song := range albumSongs {
-				metas = append(metas, song.MetaFile)
+			albumCollection := newAlbumCollection(albumSongs, lib)
+			if versions := alternates[album]; len(versions) > 0 {
+				albumCollection.Format = albumFormat(albumSongs)
+				albumCollection.Alternates = newAlternates(versions, lib)
+			}
+			discography.Children = append(discography.Children, albumCollection)
+		}
+		if albums := sortedAppearsOn[artist]; len(albums) > 0 {
+			appears := Collection{Name: appearsOnName, lib: lib}
+			for _, album := range albums {
+				for _, song := range appearsOn[artist][album] {
+					appears.SongFiles = append(appears.SongFiles, song.MetaFile)
+				}
 			}
-			hash, err := extractSongHash(metas[0])
-			forbidErr(err)
-			first := lib.findSong(hash)
-			discography.Children = append(discography.Children, Collection{
-				Name:      first.Album,
-				SongFiles: metas,
-				lib:       lib,
-				FirstSong: first.File,
-			})
+			appears.FirstSong = appearsOn[artist][albums[0]][0].File
+			discography.Children = append(discography.Children, appears)
 		}
-		discography.FirstSong = discography.Children[0].SongFiles[0]
-		hash, err := extractSongHash(discography.FirstSong)
-		forbidErr(err)
-		first := lib.findSong(hash)
-		discography.Name = first.AlbumArtist
-		if len(discography.Name) == 0 {
-			discography.Name = first.Artist
+		discography.FirstSong = discography.Children[0].FirstSong
+		sortName := args.variousArtists
+		if artist == artistKey(args.variousArtists) {
+			discography.Name = args.variousArtists
+		} else {
+			discography.Name, sortName = artistName(aa[artist], appearsOn[artist], artist, args.aliases)
 		}
 		result.Children = append(result.Children, discography)
+		sortNames = append(sortNames, sortName)
 	}
+	sort.Stable(collectionsByName{collections: result.Children, sortNames: sortNames, collator: collator})
 
 	logger.Printf("organized Library into ArtistAlbumDate collection in %v", time.Now().Sub(start))
 	logger.Printf("  Song Count:   %v", result.SongCount())
@@ -55,43 +94,175 @@ func ArtistAblumDateCollection(lib Library, logger *log.Logger) Collection {
 	return result
 }
 
-func sortArtistAlbumDate(aa artistAlbum) ([]string, map[string][]string) {
-	var (
-		artists []string
-		albums  = make(map[string][]string)
-	)
+// newAlbumCollection returns a collection of the songs of an album, already sorted by disc and track.
+// Multi-disc albums, with songs of more than one disc or tagged with more than one total disc,
+// have a child for each disc instead, named by disc number and subtitle, ex. "Disc 2: The Live Set".
+func newAlbumCollection(songs []*Song, lib Library) Collection {
+	result := Collection{
+		Name:      songs[0].Album,
+		FirstSong: songs[0].File,
+		lib:       lib,
+	}
+	multiDisc := false
+	for _, song := range songs {
+		multiDisc = multiDisc || song.Disc != songs[0].Disc || song.TotalDiscs > 1
+	}
+	if !multiDisc {
+		for _, song := range songs {
+			result.SongFiles = append(result.SongFiles, song.MetaFile)
+		}
+		return result
+	}
+	for _, song := range songs {
+		last := len(result.Children) - 1
+		if last < 0 || result.Children[last].Disc != song.Disc {
+			result.Children = append(result.Children, Collection{Disc: song.Disc, FirstSong: song.File, lib: lib})
+			last++
+		}
+		disc := &result.Children[last]
+		disc.SongFiles = append(disc.SongFiles, song.MetaFile)
+		if len(disc.DiscSubtitle) == 0 {
+			disc.DiscSubtitle = song.DiscSubtitle
+		}
+	}
+	for i := range result.Children {
+		result.Children[i].Name = discName(result.Children[i])
+	}
+	return result
+}
+
+// discName returns the name of a disc, ex. "Disc 2: The Live Set", "Disc 2", or "No Disc Number".
+func discName(disc Collection) string {
+	name := "No Disc Number"
+	if disc.Disc > 0 {
+		name = fmt.Sprintf("Disc %d", disc.Disc)
+	}
+	if len(disc.DiscSubtitle) > 0 {
+		name += ": " + disc.DiscSubtitle
+	}
+	return name
+}
 
+// sortArtistAlbumDate returns the album keys of each artist, sorted by date, then by name.
+func sortArtistAlbumDate(aa artistAlbum, collator *nameCollator) map[string][]string {
+	albums := make(map[string][]string)
 	for artistKey, discography := range aa {
-		byDate := make(map[string][]string)
+		dates := make(map[string]partialDate)
+		var sortedAlbums []string
 		for albumKey, songs := range discography {
 			if len(songs) == 0 {
 				continue
 			}
 			sort.Slice(songs, compareSongTrack(songs))
-			date := songs[0].Date
-			byDate[date] = append(byDate[date], albumKey)
+			dates[albumKey] = songSortDate(songs[0])
+			sortedAlbums = append(sortedAlbums, albumKey)
 		}
+		byName := compareAlbumName(discography, sortedAlbums, collator)
+		sort.Slice(sortedAlbums, func(i, j int) bool {
+			dateI, dateJ := dates[sortedAlbums[i]], dates[sortedAlbums[j]]
+			if dateI != dateJ {
+				return dateI.before(dateJ)
+			}
+			return byName(i, j)
+		})
+		albums[artistKey] = sortedAlbums
+	}
+	return albums
+}
 
-		var dates []string
-		for date, dateAlbums := range byDate {
-			dates = append(dates, date)
-			sort.Strings(dateAlbums)
+// compareAlbumName sorts album keys by album name, then by key for albums of the same name.
+func compareAlbumName(discography unsorted, albumKeys []string, collator *nameCollator) func(i, j int) bool {
+	return func(i, j int) bool {
+		nameI := discography[albumKeys[i]][0].Album
+		nameJ := discography[albumKeys[j]][0].Album
+		if order := collator.compare(nameI, nameJ); order != 0 {
+			return order < 0
 		}
-		sort.Strings(dates)
+		return albumKeys[i] < albumKeys[j]
+	}
+}
 
-		var sortedAlbums []string
-		for _, date := range dates {
-			for _, album := range byDate[date] {
-				sortedAlbums = append(sortedAlbums, album)
+// collectionsByName sorts collections by sort name.
+type collectionsByName struct {
+	collections []Collection
+	sortNames   []string
+	collator    *nameCollator
+}
+
+func (c collectionsByName) Len() int {
+	return len(c.collections)
+}
+
+func (c collectionsByName) Less(i, j int) bool {
+	return c.collator.compare(c.sortNames[i], c.sortNames[j]) < 0
+}
+
+func (c collectionsByName) Swap(i, j int) {
+	c.collections[i], c.collections[j] = c.collections[j], c.collections[i]
+	c.sortNames[i], c.sortNames[j] = c.sortNames[j], c.sortNames[i]
+}
+
+// artistName returns the artist of the discography as tagged, ex. "Beyoncé" for key "beyonce", and its sort name,
+// ex. "Beatles, The" if tagged, else the name. These are taken from the album artists of its albums,
+// or else from the artists of the songs it appears on. Sort names are only used for songs of a single artist.
+// Artists renamed by an alias have the alias name.
+func artistName(discography, appearsOn unsorted, key string, aliases *artistAliases) (string, string) {
+	for _, album := range sortedAlbumSongs(discography) {
+		artists := albumArtists(album)
+		for _, name := range artists {
+			if aliases.key(name) != key {
+				continue
+			}
+			name = aliases.canonical(name)
+			for _, song := range album {
+				if sortName := songAlbumArtistSort(song); len(sortName) > 0 && len(artists) == 1 {
+					return name, sortName
+				}
 			}
+			return name, name
 		}
+	}
+	for _, album := range sortedAlbumSongs(appearsOn) {
+		for _, song := range album {
+			artists := song.Artists
+			if len(artists) == 0 {
+				artists = []string{song.Artist}
+			}
+			for _, name := range artists {
+				if aliases.key(name) != key {
+					continue
+				}
+				name = aliases.canonical(name)
+				if len(song.ArtistSort) > 0 && len(artists) == 1 {
+					return name, song.ArtistSort
+				}
+				return name, name
+			}
+		}
+	}
+	return key, key
+}
 
-		artists = append(artists, artistKey)
-		albums[artistKey] = sortedAlbums
+// songAlbumArtistSort returns the sort name of the album artist of a song, or of its artist if it has no album artist.
+func songAlbumArtistSort(song *Song) string {
+	if len(song.AlbumArtist) > 0 {
+		return song.AlbumArtistSort
 	}
+	return song.ArtistSort
+}
 
-	sort.Strings(artists)
-	return artists, albums
+// sortedAlbumSongs returns the songs of each album, sorted by album key.
+func sortedAlbumSongs(discography unsorted) [][]*Song {
+	var albumKeys []string
+	for albumKey := range discography {
+		albumKeys = append(albumKeys, albumKey)
+	}
+	sort.Strings(albumKeys)
+	var result [][]*Song
+	for _, albumKey := range albumKeys {
+		result = append(result, discography[albumKey])
+	}
+	return result
 }
 
 func compareSongTrack(songs []*Song) func(i, j int) bool {
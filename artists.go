@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// artistKey returns the key artists are grouped by, ignoring case, diacritics and repeated whitespace,
+// so that "Beyonce" and "Beyoncé" are the same artist.
+func artistKey(name string) string {
+	return strings.Join(strings.Fields(foldText(name)), " ")
+}
+
+// artistAliases renames artists, by exact name or by regular expression, see artistAlias.
+// A nil *artistAliases renames nothing.
+type artistAliases struct {
+	exact   map[string]string // artist key to name
+	regexes []*regexp.Regexp
+	names   []string // name of each regex
+}
+
+func newArtistAliases(aliases []artistAlias) (*artistAliases, error) {
+	result := &artistAliases{exact: make(map[string]string)}
+	for _, alias := range aliases {
+		switch {
+		case len(alias.Name) == 0:
+			return nil, fmt.Errorf("artist alias has no name")
+		case len(alias.Match) > 0 && len(alias.Regex) > 0:
+			return nil, fmt.Errorf("artist alias %q has both match and regex", alias.Name)
+		case len(alias.Match) > 0:
+			result.exact[artistKey(alias.Match)] = alias.Name
+		case len(alias.Regex) > 0:
+			regex, err := regexp.Compile(alias.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("artist alias %q has invalid regex: %v", alias.Name, err)
+			}
+			result.regexes = append(result.regexes, regex)
+			result.names = append(result.names, alias.Name)
+		default:
+			return nil, fmt.Errorf("artist alias %q has no match or regex", alias.Name)
+		}
+	}
+	return result, nil
+}
+
+// canonical returns the name an artist is renamed to by the first matching alias, exact aliases first,
+// or the name itself if none match.
+func (a *artistAliases) canonical(name string) string {
+	if a == nil {
+		return name
+	}
+	if alias, ok := a.exact[artistKey(name)]; ok {
+		return alias
+	}
+	for i, regex := range a.regexes {
+		if regex.MatchString(name) {
+			return a.names[i]
+		}
+	}
+	return name
+}
+
+// key returns the artist key of the canonical name of an artist.
+func (a *artistAliases) key(name string) string {
+	return artistKey(a.canonical(name))
+}
+
+// duplicateArtists are artist names that are probably the same artist.
+type duplicateArtists struct {
+	Names  []string `json:"names"`
+	Songs  []int    `json:"songs"` // song count of each name
+	Reason string   `json:"reason"`
+	Merged bool     `json:"merged"` // true if already grouped together
+}
+
+// findDuplicateArtists finds artist names, after aliases, that differ only by case, diacritics or whitespace,
+// which are merged automatically, or that differ only by punctuation, or by a typo or two, which are not.
+func findDuplicateArtists(lib Library, aliases *artistAliases) []duplicateArtists {
+	songCounts := make(map[string]int)
+	forbidErr(lib.songs(func(song *Song) error {
+		for _, name := range songArtists(song) {
+			songCounts[aliases.canonical(name)]++
+		}
+		return nil
+	}))
+	byKey := make(map[string][]string)
+	for name := range songCounts {
+		key := artistKey(name)
+		byKey[key] = append(byKey[key], name)
+	}
+	var keys []string
+	for key, names := range byKey {
+		keys = append(keys, key)
+		sort.Strings(names)
+	}
+	sort.Strings(keys)
+
+	var result []duplicateArtists
+	add := func(names []string, reason string, merged bool) {
+		sort.Strings(names)
+		duplicate := duplicateArtists{Names: names, Reason: reason, Merged: merged}
+		for _, name := range names {
+			duplicate.Songs = append(duplicate.Songs, songCounts[name])
+		}
+		result = append(result, duplicate)
+	}
+	for _, key := range keys {
+		if len(byKey[key]) > 1 {
+			add(byKey[key], "same name ignoring case, accents and spaces", true)
+		}
+	}
+	for i, keyI := range keys {
+		for _, keyJ := range keys[i+1:] {
+			if alphanumeric(keyI) == alphanumeric(keyJ) {
+				add([]string{byKey[keyI][0], byKey[keyJ][0]}, "same name ignoring punctuation and spaces", false)
+				continue
+			}
+			maxTypos := 0
+			if length := len([]rune(keyI)); length >= minTwoTypoLength {
+				maxTypos = 2
+			} else if length >= minTypoLength {
+				maxTypos = 1
+			}
+			if maxTypos > 0 && editDistance(keyI, keyJ, maxTypos) <= maxTypos {
+				add([]string{byKey[keyI][0], byKey[keyJ][0]}, "close spelling", false)
+			}
+		}
+	}
+	return result
+}
+
+// alphanumeric removes everything but letters and numbers.
+func alphanumeric(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return -1
+	}, text)
+}
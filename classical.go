@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// composer of classical songs without one
+const unknownComposer = "Unknown Composer"
+
+// ClassicalCollection builds a collection by composer/work/recording, of classical songs, see isClassical.
+// A recording is the songs of a work on one album, see songAlbumKey, in movement order, with their performers.
+// Composers and works are sorted by name, see nameCollator, and recordings by original release date.
+// Songs with more than one composer are listed under each of them. Composers renamed by an alias have the alias name.
+func ClassicalCollection(lib Library, args collectionArgs, logger *log.Logger) Collection {
+	start := time.Now()
+	// composer key to work key to album key to songs
+	composers := make(map[string]map[string]unsorted)
+	names := make(map[string]string) // composer and work keys to the least of their spellings, for a stable name
+	forbidErr(lib.songs(func(song *Song) error {
+		if !isClassical(song) {
+			return nil
+		}
+		work := songWork(song)
+		workKey := artistKey(work)
+		for _, composer := range songComposers(song) {
+			composer = args.aliases.canonical(composer)
+			key := artistKey(composer)
+			works, ok := composers[key]
+			if !ok {
+				works = make(map[string]unsorted)
+				composers[key] = works
+			}
+			recordings, ok := works[workKey]
+			if !ok {
+				recordings = make(unsorted)
+				works[workKey] = recordings
+			}
+			albumKey := songAlbumKey(song)
+			recordings[albumKey] = append(recordings[albumKey], song)
+			if name, ok := names[key]; !ok || composer < name {
+				names[key] = composer
+			}
+			if name, ok := names[key+"\x00"+workKey]; !ok || work < name {
+				names[key+"\x00"+workKey] = work
+			}
+		}
+		return nil
+	}))
+
+	collator := newNameCollator(args.locale, args.articles)
+	result := Collection{
+		Name: "ClassicalCollection",
+		lib:  lib,
+	}
+	var composerNames []string
+	for key, works := range composers {
+		composer := Collection{Name: names[key], lib: lib}
+		var workNames []string
+		for workKey, recordings := range works {
+			work := Collection{Name: names[key+"\x00"+workKey], lib: lib}
+			for _, albumKey := range sortRecordings(recordings) {
+				work.Children = append(work.Children, newRecording(recordings[albumKey], lib))
+			}
+			work.FirstSong = work.Children[0].FirstSong
+			composer.Children = append(composer.Children, work)
+			workNames = append(workNames, work.Name)
+		}
+		sort.Stable(collectionsByName{collections: composer.Children, sortNames: workNames, collator: collator})
+		composer.FirstSong = composer.Children[0].FirstSong
+		result.Children = append(result.Children, composer)
+		composerNames = append(composerNames, composer.Name)
+	}
+	sort.Stable(collectionsByName{collections: result.Children, sortNames: composerNames, collator: collator})
+
+	logger.Printf("organized Library into Classical collection in %v", time.Now().Sub(start))
+	logger.Printf("  Song Count:   %v", result.SongCount())
+	return result
+}
+
+// isClassical returns true if a song has a work, or has a composer and a classical genre.
+func isClassical(song *Song) bool {
+	if len(song.Work) > 0 {
+		return true
+	}
+	return len(song.Composer) > 0 && hasClassicalGenre(song)
+}
+
+// hasClassicalGenre returns true if a genre of a song contains "classical", ex. "Classical" or "Neoclassical".
+func hasClassicalGenre(song *Song) bool {
+	genres := song.Genres
+	if len(genres) == 0 {
+		genres = []string{song.Genre}
+	}
+	for _, genre := range genres {
+		if strings.Contains(strings.ToLower(genre), "classical") {
+			return true
+		}
+	}
+	return false
+}
+
+// songWork returns the work of a song, or else the part of its title before a colon,
+// ex. "Symphony No. 5 in C minor, Op. 67" for "Symphony No. 5 in C minor, Op. 67: I. Allegro con brio",
+// or else its title.
+func songWork(song *Song) string {
+	if len(song.Work) > 0 {
+		return song.Work
+	}
+	if colon := strings.Index(song.Title, ": "); colon > 0 {
+		return strings.TrimSpace(song.Title[:colon])
+	}
+	return song.Title
+}
+
+// songComposers returns each composer of a song, or the unknown composer if it has none.
+func songComposers(song *Song) []string {
+	if len(song.Composers) > 0 {
+		return song.Composers
+	}
+	if len(song.Composer) > 0 {
+		return []string{song.Composer}
+	}
+	return []string{unknownComposer}
+}
+
+// sortRecordings returns the album keys of the recordings of a work, sorted by date, then by key,
+// sorting the songs of each recording by movement, see compareSongMovement.
+func sortRecordings(recordings unsorted) []string {
+	dates := make(map[string]partialDate)
+	var result []string
+	for albumKey, songs := range recordings {
+		sort.Slice(songs, compareSongMovement(songs))
+		dates[albumKey] = songSortDate(songs[0])
+		result = append(result, albumKey)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		dateI, dateJ := dates[result[i]], dates[result[j]]
+		if dateI != dateJ {
+			return dateI.before(dateJ)
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// compareSongMovement sorts songs by movement number, if they have one, else by disc and track.
+func compareSongMovement(songs []*Song) func(i, j int) bool {
+	byTrack := compareSongTrack(songs)
+	return func(i, j int) bool {
+		movementI, movementJ := songs[i].Movement, songs[j].Movement
+		if movementI > 0 && movementJ > 0 && movementI != movementJ {
+			return movementI < movementJ
+		}
+		return byTrack(i, j)
+	}
+}
+
+// newRecording returns a recording of the songs of a work, already sorted by movement, named by its performers,
+// or by its album if it has none, and its release year, ex. "Carlos Kleiber, Wiener Philharmoniker (1975)"
+func newRecording(songs []*Song, lib Library) Collection {
+	result := Collection{
+		Performers: recordingPerformers(songs),
+		FirstSong:  songs[0].File,
+		lib:        lib,
+	}
+	for _, song := range songs {
+		result.SongFiles = append(result.SongFiles, song.MetaFile)
+	}
+	result.Name = songs[0].Album
+	if len(result.Performers) > 0 {
+		result.Name = strings.Join(result.Performers, ", ")
+	}
+	if date := songSortDate(songs[0]); date.Year > 0 {
+		result.Name = fmt.Sprintf("%s (%d)", result.Name, date.Year)
+	}
+	return result
+}
+
+// recordingPerformers returns the conductors, orchestras and performers of the songs of a recording, without repeats,
+// or else their artists that are not composers.
+func recordingPerformers(songs []*Song) []string {
+	var performers []string
+	for _, song := range songs {
+		if len(song.Conductor) > 0 {
+			performers = append(performers, song.Conductor)
+		}
+	}
+	for _, song := range songs {
+		if len(song.Orchestra) > 0 {
+			performers = append(performers, song.Orchestra)
+		}
+	}
+	for _, song := range songs {
+		performers = append(performers, song.Performers...)
+	}
+	if len(performers) > 0 {
+		return splitTagValues(performers, nil)
+	}
+	for _, song := range songs {
+		composers := make(map[string]bool)
+		for _, composer := range songComposers(song) {
+			composers[artistKey(composer)] = true
+		}
+		for _, artist := range songArtists(song) {
+			if !composers[artistKey(artist)] {
+				performers = append(performers, artist)
+			}
+		}
+	}
+	return splitTagValues(performers, nil)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"strings"
+)
+
+// nameCollator orders artist and album names by the unicode collation of a locale, so that "Émilie Simon" sorts
+// with the E's, ignoring leading articles, so that "The Beatles" sorts with the B's. Names shown are not changed.
+// A nameCollator is not safe for concurrent use.
+type nameCollator struct {
+	collator *collate.Collator
+	articles []string // lowercase
+}
+
+func newNameCollator(locale language.Tag, articles []string) *nameCollator {
+	return &nameCollator{collator: collate.New(locale, collate.IgnoreCase), articles: articles}
+}
+
+// parseSortArticles parses comma separated leading articles, ex. "the,a,les,l'"
+func parseSortArticles(articles string) []string {
+	var result []string
+	for _, article := range strings.Split(articles, ",") {
+		if article = strings.ToLower(strings.TrimSpace(article)); len(article) > 0 {
+			result = append(result, article)
+		}
+	}
+	return result
+}
+
+// sortName returns a name without its leading article, if any.
+// Articles are followed by a space, except those ending with an apostrophe, ex. l'
+func (c *nameCollator) sortName(name string) string {
+	for _, article := range c.articles {
+		if len(article) >= len(name) || !strings.EqualFold(name[:len(article)], article) {
+			continue
+		}
+		if strings.HasSuffix(article, "'") || name[len(article)] == ' ' {
+			if rest := strings.TrimSpace(name[len(article):]); len(rest) > 0 {
+				return rest
+			}
+		}
+	}
+	return name
+}
+
+// compare compares sort names, see sortName, returning -1, 0 or 1.
+func (c *nameCollator) compare(a, b string) int {
+	return c.collator.CompareString(c.sortName(a), c.sortName(b))
+}
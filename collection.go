@@ -15,6 +15,15 @@ type Collection struct {
 	SongFiles []string `json:"song_files,omitempty"`
 	// First song of all children, or empty if no children.
 	FirstSong string `json:"first_song,omitempty"`
+	// Performers of a classical recording, see ClassicalCollection.
+	Performers []string `json:"performers,omitempty"`
+	// Format of the songs of an album in more than one format, see preferFormats.
+	Format tag.FileType `json:"format,omitempty"`
+	// Versions of an album in formats less preferred than this one, not counted as songs of this collection.
+	Alternates []Collection `json:"alternates,omitempty"`
+	// Disc number and subtitle of a disc of a multi-disc album, see newAlbumCollection.
+	Disc         int    `json:"disc,omitempty"`
+	DiscSubtitle string `json:"disc_subtitle,omitempty"`
 	// The library this collection describes.
 	lib Library
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// config is the optional json config file, ex.
+//
+//	{
+//	  "mobile_profiles": {"phone": {"mobile": "/media/phone/Music", "fs": "fat", "budget": "64G"}},
+//	  "schedules": [{"cron": "0 3 * * *", "job": "scan"}, {"cron": "0 4 * * *", "job": "sync-mobile:phone"}],
+//	  "artist_aliases": [{"name": "Sigur Rós", "match": "Sigur Ros"}, {"name": "Prince", "regex": "^(?i)tafkap$"}]
+//	}
+type config struct {
+	// named mobile libraries, options not given are the same as the command line mobile options
+	MobileProfiles map[string]json.RawMessage `json:"mobile_profiles"`
+	// jobs to start periodically
+	Schedules []scheduleConfig `json:"schedules"`
+	// artists to list under another name
+	ArtistAliases []artistAlias `json:"artist_aliases"`
+}
+
+// scheduleConfig is a kind of job to start whenever the cron expression matches, see parseCron.
+type scheduleConfig struct {
+	Cron string `json:"cron"`
+	Job  string `json:"job"`
+}
+
+// artistAlias lists an artist under another name, if it has the same name as match, ignoring case, accents and spaces,
+// or if it matches the regular expression.
+type artistAlias struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	Regex string `json:"regex"`
+}
+
+// mobileProfile is a named mobile library with its own sync options, see mobileSyncArgs.
+type mobileProfile struct {
+	Mobile         string `json:"mobile"`
+	Budget         string `json:"budget"`
+	Priority       string `json:"priority"`
+	FS             string `json:"fs"`
+	Playlists      string `json:"playlists"`
+	AlbumPlaylists bool   `json:"album_playlists"`
+	ArtSize        int    `json:"art_size"`
+	ArtQuality     int    `json:"art_quality"`
+	Covers         bool   `json:"covers"`
+	EmbedArt       bool   `json:"embed_art"`
+}
+
+// readConfig reads the config file, or returns an empty config if path is empty.
+func readConfig(path string) (*config, error) {
+	result := &config{}
+	if len(path) == 0 {
+		return result, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("invalid config file %q: %v", path, err)
+	}
+	return result, nil
+}
+
+// mobileProfiles returns each named mobile profile, with any options it does not give taken from defaults.
+func (c *config) mobileProfiles(defaults mobileProfile) (map[string]mobileProfile, error) {
+	result := make(map[string]mobileProfile, len(c.MobileProfiles))
+	for name, raw := range c.MobileProfiles {
+		profile := defaults
+		if err := json.Unmarshal(raw, &profile); err != nil {
+			return nil, fmt.Errorf("invalid mobile profile %q: %v", name, err)
+		}
+		if len(profile.Mobile) == 0 {
+			return nil, fmt.Errorf("mobile profile %q has no mobile library folder", name)
+		}
+		result[name] = profile
+	}
+	return result, nil
+}
+
+// syncArgs returns the sync options of the profile, with the root library and anything else taken from base.
+func (p mobileProfile) syncArgs(base mobileSyncArgs) (mobileSyncArgs, error) {
+	budget, err := parseByteSize(p.Budget)
+	if err != nil {
+		return base, err
+	}
+	if err := checkMobileFs(p.FS); err != nil {
+		return base, err
+	}
+	result := base
+	result.mobile = ensurePathSep(p.Mobile)
+	result.budget = budget
+	result.priority = p.Priority
+	result.fs = p.FS
+	result.playlists = p.Playlists
+	result.albumPlaylists = p.AlbumPlaylists
+	result.artSize = p.ArtSize
+	result.artQuality = p.ArtQuality
+	result.covers = p.Covers
+	result.embedArt = p.EmbedArt
+	return result, nil
+}
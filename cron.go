@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far ahead to look for the next time a cron expression matches
+const maxCronLookahead = 5 * 366 * 24 * time.Hour
+
+// shorthands for common cron expressions
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed cron expression, with the allowed values of each field.
+type cronSchedule struct {
+	expr    string
+	minutes map[int]bool
+	hours   map[int]bool
+	days    map[int]bool // day of month
+	months  map[int]bool
+	weekday map[int]bool // 0 is sunday
+	anyDay  bool         // day of month is *
+	anyWeek bool         // day of week is *
+}
+
+// parseCron parses a standard five field cron expression, "minute hour day-of-month month day-of-week",
+// where each field is *, a value, a range like 1-5, a step like */15 or 0-30/10, or a comma separated list of these.
+// Shorthands like @daily and @hourly are also allowed.
+func parseCron(expr string) (*cronSchedule, error) {
+	expanded := strings.TrimSpace(expr)
+	if shorthand, ok := cronShorthands[expanded]; ok {
+		expanded = shorthand
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", expr)
+	}
+	result := &cronSchedule{
+		expr:    expr,
+		anyDay:  fields[2] == "*",
+		anyWeek: fields[4] == "*",
+	}
+	var err error
+	if result.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minutes in cron expression %q: %v", expr, err)
+	}
+	if result.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hours in cron expression %q: %v", expr, err)
+	}
+	if result.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in cron expression %q: %v", expr, err)
+	}
+	if result.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in cron expression %q: %v", expr, err)
+	}
+	if result.weekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in cron expression %q: %v", expr, err)
+	}
+	if result.weekday[7] {
+		result.weekday[0] = true // 7 is also sunday
+	}
+	return result, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	result := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", part)
+			}
+			part = part[:slash]
+		}
+		low, high := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				high = max // like 5/15, every 15 starting at 5
+			}
+			if low < min || high > max || low > high {
+				return nil, fmt.Errorf("%q is out of range %v-%v", part, min, max)
+			}
+		}
+		for i := low; i <= high; i += step {
+			result[i] = true
+		}
+	}
+	return result, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	day, weekday := c.days[t.Day()], c.weekday[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeek:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeek:
+		return day
+	default:
+		return day || weekday // like cron, either matches if both are restricted
+	}
+}
+
+// next returns the first time after t that matches, in the location of t, or the zero time if there is none.
+// Local times skipped by a daylight saving change never match.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(maxCronLookahead)
+	for t.Before(end) {
+		switch {
+		case !c.months[int(t.Month())]:
+			t = nextCronTime(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !c.matchesDay(t):
+			t = nextCronTime(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case !c.hours[t.Hour()]:
+			t = nextHour(t)
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// nextHour returns the start of the hour after t, where t is a whole minute.
+func nextHour(t time.Time) time.Time {
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+// nextCronTime returns next if it is after t, otherwise the start of the hour after t.
+// time.Date normalizes a local time skipped by a daylight saving change to an earlier time,
+// which may not be after t.
+func nextCronTime(t time.Time, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return nextHour(t)
+}
+
+func (c *cronSchedule) String() string {
+	return c.expr
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@never",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) did not fail", expr)
+		}
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{field: "*", min: 0, max: 6, want: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "3", min: 0, max: 59, want: []int{3}},
+		{field: "1-5", min: 0, max: 6, want: []int{1, 2, 3, 4, 5}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "0-30/10", min: 0, max: 59, want: []int{0, 10, 20, 30}},
+		{field: "5/20", min: 0, max: 59, want: []int{5, 25, 45}},
+		{field: "1,3,5-6", min: 0, max: 6, want: []int{1, 3, 5, 6}},
+	}
+	for _, test := range tests {
+		got, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q) failed: %v", test.field, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+			continue
+		}
+		for _, value := range test.want {
+			if !got[value] {
+				t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2026-03-04 is a wednesday
+	from := time.Date(2026, 3, 4, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{expr: "* * * * *", from: from, want: time.Date(2026, 3, 4, 10, 31, 0, 0, time.UTC)},
+		{expr: "0 3 * * *", from: from, want: time.Date(2026, 3, 5, 3, 0, 0, 0, time.UTC)},
+		{expr: "30 10 * * *", from: time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 5, 10, 30, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", from: from, want: time.Date(2026, 3, 4, 10, 45, 0, 0, time.UTC)},
+		{expr: "0 4 * * 1-5", from: time.Date(2026, 3, 6, 5, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 9, 4, 0, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", from: from, want: time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{expr: "@weekly", from: from, want: time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", from: from, want: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "@yearly", from: from, want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 * *", from: from, want: time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 4 *", from: from, want: time.Time{}},
+		{expr: "0 0 29 2 *", from: from, want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day of month or day of week, like cron
+		{expr: "0 0 15 * 5", from: from, want: time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 5 * 0", from: from, want: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 12 * 12 *", from: from, want: time.Date(2026, 12, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if got := schedule.next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q next after %v = %v, want %v", test.expr, test.from, got, test.want)
+		}
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data", err)
+	}
+	schedule, err := parseCron("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2:30 does not exist on 2026-03-08, clocks go from 2:00 to 3:00
+	from := time.Date(2026, 3, 7, 12, 0, 0, 0, location)
+	want := time.Date(2026, 3, 9, 2, 30, 0, 0, location)
+	if got := schedule.next(from); !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", from, got, want)
+	}
+	schedule, err = parseCron("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = time.Date(2026, 3, 8, 3, 0, 0, 0, location)
+	if got := schedule.next(from); !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", from, got, want)
+	}
+
+	// midnight did not exist on 2018-11-04, clocks went from 0:00 to 1:00
+	location, err = time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip("no time zone data", err)
+	}
+	if schedule, err = parseCron("@daily"); err != nil {
+		t.Fatal(err)
+	}
+	from = time.Date(2018, 11, 3, 12, 0, 0, 0, location)
+	want = time.Date(2018, 11, 5, 0, 0, 0, 0, location)
+	if got := schedule.next(from); !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", from, got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// partialDate is a date known to year, month or day precision, ex. 2003, 2003-05 or 2003-05-12.
+// Unknown month and day are zero.
+type partialDate struct {
+	Year  int `json:"year"`
+	Month int `json:"month,omitempty"`
+	Day   int `json:"day,omitempty"`
+}
+
+var (
+	// 2003, 2003-05, 2003-05-12, 2003/05/12, 2003.05.12, 20030512, and ISO-8601 timestamps
+	yearFirstDate = regexp.MustCompile(`^(\d{4})(?:[-/.]?(\d{1,2})(?:[-/.]?(\d{1,2}))?)?(?:[T ].*)?$`)
+	// 05/12/2003 (month first, unless the first number is over 12), or 12.05.2003 (day first)
+	yearLastDate = regexp.MustCompile(`^(\d{1,2})([-/.])(\d{1,2})([-/.])(\d{4})$`)
+	// any other date with a year in it, ex. "(P) 1999 Mushroom" is 1999
+	anyYear = regexp.MustCompile(`(?:^|\D)(\d{4})(?:\D|$)`)
+)
+
+// parseDate parses a tagged date to the precision it has, or returns false if it has no year.
+func parseDate(raw string) (partialDate, bool) {
+	var year, month, day string
+	if match := yearFirstDate.FindStringSubmatch(raw); match != nil {
+		year, month, day = match[1], match[2], match[3]
+	} else if match := yearLastDate.FindStringSubmatch(raw); match != nil && match[2] == match[4] {
+		year, month, day = match[5], match[1], match[3]
+		if first, _ := strconv.Atoi(match[1]); first > 12 || match[2] == "." {
+			month, day = day, month
+		}
+	} else if match := anyYear.FindStringSubmatch(raw); match != nil {
+		year = match[1]
+	} else {
+		return partialDate{}, false
+	}
+
+	var result partialDate
+	result.Year, _ = strconv.Atoi(year)
+	if result.Year == 0 {
+		return partialDate{}, false
+	}
+	result.Month, _ = strconv.Atoi(month)
+	if result.Month < 1 || result.Month > 12 {
+		return partialDate{Year: result.Year}, true
+	}
+	result.Day, _ = strconv.Atoi(day)
+	daysInMonth := time.Date(result.Year, time.Month(result.Month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if result.Day < 1 || result.Day > daysInMonth {
+		result.Day = 0
+	}
+	return result, true
+}
+
+// String formats the date as ISO-8601 to its precision, ex. 2003-05
+func (d partialDate) String() string {
+	switch {
+	case d.Year == 0:
+		return ""
+	case d.Month == 0:
+		return fmt.Sprintf("%04d", d.Year)
+	case d.Day == 0:
+		return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// before returns true if d sorts before other. Dates of less precision sort first, and unknown dates last.
+func (d partialDate) before(other partialDate) bool {
+	switch {
+	case d.Year == 0 || other.Year == 0:
+		return d.Year != 0 && other.Year == 0
+	case d.Year != other.Year:
+		return d.Year < other.Year
+	case d.Month != other.Month:
+		return d.Month < other.Month
+	}
+	return d.Day < other.Day
+}
+
+// parseDates parses the tagged dates of a song, see parseDate.
+func (s *Song) parseDates() {
+	s.ParsedDate, s.ParsedOriginalDate = nil, nil
+	if date, ok := parseDate(s.Date); ok {
+		s.ParsedDate = &date
+	}
+	if date, ok := parseDate(s.OriginalDate); ok {
+		s.ParsedOriginalDate = &date
+	}
+}
+
+// songSortDate returns the date a song is sorted by, which is its original release date if tagged,
+// so that reissues and remasters sort with the original album.
+func songSortDate(song *Song) partialDate {
+	if date, ok := parseDate(song.OriginalDate); ok {
+		return date
+	}
+	date, _ := parseDate(song.Date)
+	return date
+}
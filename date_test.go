@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // "" if it has no year
+	}{
+		{raw: "2003", want: "2003"},
+		{raw: "2003-05", want: "2003-05"},
+		{raw: "2003-05-12", want: "2003-05-12"},
+		{raw: "2003/5/12", want: "2003-05-12"},
+		{raw: "2003.05.12", want: "2003-05-12"},
+		{raw: "20030512", want: "2003-05-12"},
+		{raw: "2003-05-12T10:30:00Z", want: "2003-05-12"},
+		{raw: "2003-05-12 10:30", want: "2003-05-12"},
+		{raw: "05/12/2003", want: "2003-05-12"},
+		{raw: "25/12/2003", want: "2003-12-25"},
+		{raw: "12.05.2003", want: "2003-05-12"},
+		{raw: "05/12-2003", want: "2003"},
+		{raw: "2003-13-01", want: "2003"},
+		{raw: "2003-02-30", want: "2003-02"},
+		{raw: "2004-02-29", want: "2004-02-29"},
+		{raw: "(P) 1999 Mushroom", want: "1999"},
+		{raw: "0000", want: ""},
+		{raw: "May", want: ""},
+		{raw: "", want: ""},
+	}
+	for _, test := range tests {
+		date, ok := parseDate(test.raw)
+		if ok != (len(test.want) > 0) || date.String() != test.want {
+			t.Errorf("parseDate(%q) = %q, %v, want %q", test.raw, date, ok, test.want)
+		}
+	}
+}
+
+func TestPartialDateBefore(t *testing.T) {
+	ordered := []partialDate{
+		{Year: 1999},
+		{Year: 1999, Month: 5},
+		{Year: 1999, Month: 5, Day: 2},
+		{Year: 1999, Month: 6},
+		{Year: 2003},
+		{},
+	}
+	for i, d := range ordered {
+		for j, other := range ordered {
+			if got := d.before(other); got != (i < j) {
+				t.Errorf("%q before %q = %v, want %v", d, other, got, i < j)
+			}
+		}
+	}
+}
+
+func TestSongSortDate(t *testing.T) {
+	tests := []struct {
+		date, originalDate string
+		want               string
+	}{
+		{date: "2011-03-01", originalDate: "1973-03-01", want: "1973-03-01"},
+		{date: "2011-03-01", originalDate: "unknown", want: "2011-03-01"},
+		{date: "", want: ""},
+	}
+	for _, test := range tests {
+		song := &Song{Date: test.date, OriginalDate: test.originalDate}
+		if got := songSortDate(song).String(); got != test.want {
+			t.Errorf("sort date of %q, original %q = %q, want %q", test.date, test.originalDate, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// songs whose durations differ by at most this many seconds may be the same recording,
+// allowing for encoder delay and padding
+const maxDuplicateDurationDiff = 2.0
+
+// duplicateSongs are song files that are, or are probably, copies of the same recording.
+type duplicateSongs struct {
+	Reason string          `json:"reason"`
+	Songs  []duplicateSong `json:"songs"`
+}
+
+type duplicateSong struct {
+	Hash     string  `json:"hash"`
+	Path     string  `json:"path"`
+	FileType string  `json:"file_type,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// findDuplicateSongs finds files with identical audio, of which the library keeps only one song,
+// see library.putSongAndArt, and songs with different audio that are likely the same recording,
+// ex. a FLAC and an MP3 of it, because they have the same artist, album, title, disc and track,
+// and durations that differ by at most maxDuplicateDurationDiff, if both are known.
+// Artists are compared after aliases, ignoring case and diacritics. Songs without a title are not compared.
+func findDuplicateSongs(lib Library, aliases *artistAliases) []duplicateSongs {
+	var result []duplicateSongs
+	recordings := make(map[string][]*Song)
+	forbidErr(lib.songs(func(song *Song) error {
+		if len(song.DuplicatePaths) > 0 {
+			duplicates := duplicateSongs{Reason: "identical audio"}
+			for _, path := range append([]string{song.Path}, song.DuplicatePaths...) {
+				duplicates.Songs = append(duplicates.Songs, newDuplicateSong(song, path))
+			}
+			result = append(result, duplicates)
+		}
+		if len(strings.TrimSpace(song.Title)) == 0 {
+			return nil
+		}
+		key := strings.Join([]string{
+			aliases.key(song.Artist), foldText(song.Album), foldText(song.Title),
+			fmt.Sprint(song.Disc), fmt.Sprint(song.Track),
+		}, "\x00")
+		recordings[key] = append(recordings[key], song)
+		return nil
+	}))
+
+	for _, songs := range recordings {
+		if len(songs) < 2 {
+			continue
+		}
+		sort.Slice(songs, func(i, j int) bool {
+			return songs[i].Path < songs[j].Path
+		})
+		for _, similar := range similarDurations(songs) {
+			if len(similar) < 2 {
+				continue
+			}
+			duplicates := duplicateSongs{}
+			var fileTypes []string
+			for _, song := range similar {
+				duplicates.Songs = append(duplicates.Songs, newDuplicateSong(song, song.Path))
+				if fileType := string(song.FileType); !containsFold(fileTypes, fileType) {
+					fileTypes = append(fileTypes, fileType)
+				}
+			}
+			duplicates.Reason = "same artist, album, title and track, in " + strings.Join(fileTypes, ", ")
+			result = append(result, duplicates)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Songs[0].Path < result[j].Songs[0].Path
+	})
+	return result
+}
+
+// similarDurations groups songs so that each song's duration is similar to that of every other song in its group.
+// Unknown durations are similar to any.
+func similarDurations(songs []*Song) [][]*Song {
+	var result [][]*Song
+	for _, song := range songs {
+		found := false
+		for i, group := range result {
+			similar := true
+			for _, other := range group {
+				if song.Duration > 0 && other.Duration > 0 &&
+					math.Abs(song.Duration-other.Duration) > maxDuplicateDurationDiff {
+					similar = false
+					break
+				}
+			}
+			if similar {
+				result[i] = append(group, song)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, []*Song{song})
+		}
+	}
+	return result
+}
+
+func newDuplicateSong(song *Song, path string) duplicateSong {
+	return duplicateSong{
+		Hash:     song.Hash.String(),
+		Path:     path,
+		FileType: string(song.FileType),
+		Duration: song.Duration,
+	}
+}
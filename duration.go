@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"io"
+	"os"
+	"time"
+)
+
+// songDuration reads the playback duration of a song from its file, without decoding audio.
+// FLAC and ogg durations are exact, MP3 durations are exact if the file has a Xing or VBRI header,
+// otherwise they are estimated from the bitrate of the first frame.
+func songDuration(path string, fileType tag.FileType) (time.Duration, error) {
+	switch fileType {
+	case tag.FLAC:
+		return flacDuration(path)
+	case tag.MP3:
+		return mp3Duration(path)
+	case tag.M4A, tag.M4B, tag.M4P, tag.ALAC:
+		return mp4Duration(path)
+	case tag.OGG, OPUS:
+		return oggDuration(path)
+	}
+	return 0, fmt.Errorf("unknown duration of %v file %q", fileType, path)
+}
+
+const (
+	mp3FrameHeaderSize = 4
+	// how far past any ID3v2 tag to look for the first frame
+	mp3MaxFrameSearch = 64 * 1024
+)
+
+var (
+	// kbps by bitrate index, for MPEG-1 layers 1, 2 and 3, and MPEG-2 and 2.5 layer 1, and layers 2 and 3
+	mp3Bitrates = [5][16]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	// Hz by sample rate index, for MPEG-1, MPEG-2 and MPEG-2.5
+	mp3SampleRates = [3][3]int{{44100, 48000, 32000}, {22050, 24000, 16000}, {11025, 12000, 8000}}
+)
+
+// mp3Duration reads the duration of an MP3 file from the Xing or VBRI header of its first frame,
+// or estimates it from the bitrate of its first frame, which is exact for constant bitrate files.
+func mp3Duration(path string) (time.Duration, error) {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile(songFile)
+	info, err := songFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	id3, err := readID3Tag(songFile)
+	if err != nil {
+		return 0, err
+	}
+	audioStart, audioEnd := int64(0), info.Size()
+	if id3 != nil {
+		audioStart = id3.size
+	}
+	var trailer [3]byte
+	if _, err := songFile.ReadAt(trailer[:], audioEnd-id3v1Size); err == nil && string(trailer[:]) == "TAG" {
+		audioEnd -= id3v1Size
+	}
+
+	data := make([]byte, minInt(mp3MaxFrameSearch, int(maxInt64(0, audioEnd-audioStart))))
+	if _, err := songFile.ReadAt(data, audioStart); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i := 0; i+mp3FrameHeaderSize <= len(data); i++ {
+		if data[i] != 0xFF || data[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version, layer := (data[i+1]>>3)&3, (data[i+1]>>1)&3
+		bitrateIndex, rateIndex := data[i+2]>>4, (data[i+2]>>2)&3
+		if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue // reserved or free format, not a frame header
+		}
+		// version 3 is MPEG-1, 2 is MPEG-2 and 0 is MPEG-2.5, layer 3 is layer 1, 2 is 2 and 1 is 3
+		mpeg1 := version == 3
+		rates := map[byte]int{3: 0, 2: 1, 0: 2}[version]
+		var bitrates, samples int
+		switch {
+		case mpeg1:
+			bitrates = int(3 - layer)
+		case layer == 3:
+			bitrates = 3
+		default:
+			bitrates = 4
+		}
+		switch {
+		case layer == 3: // layer 1
+			samples = 384
+		case layer == 2 || mpeg1: // layer 2, or MPEG-1 layer 3
+			samples = 1152
+		default:
+			samples = 576
+		}
+		sampleRate := mp3SampleRates[rates][rateIndex]
+		frame := data[i:]
+
+		// Xing or Info header after the side information of a layer 3 frame
+		sideInfo := 17
+		if mpeg1 && data[i+3]>>6 != 3 {
+			sideInfo = 32
+		} else if !mpeg1 && data[i+3]>>6 == 3 {
+			sideInfo = 9
+		}
+		if xing := mp3FrameHeaderSize + sideInfo; len(frame) >= xing+12 &&
+			(string(frame[xing:xing+4]) == "Xing" || string(frame[xing:xing+4]) == "Info") &&
+			frame[xing+7]&1 != 0 {
+			frames := binary.BigEndian.Uint32(frame[xing+8:])
+			return time.Duration(frames) * time.Duration(samples) * time.Second / time.Duration(sampleRate), nil
+		}
+		if vbri := mp3FrameHeaderSize + 32; len(frame) >= vbri+18 && string(frame[vbri:vbri+4]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(frame[vbri+14:])
+			return time.Duration(frames) * time.Duration(samples) * time.Second / time.Duration(sampleRate), nil
+		}
+		bitrate := int64(mp3Bitrates[bitrates][bitrateIndex]) * 1000
+		audioBytes := audioEnd - audioStart - int64(i)
+		return time.Duration(audioBytes*8) * time.Second / time.Duration(bitrate), nil
+	}
+	return 0, fmt.Errorf("MP3 file %q has no frames", path)
+}
+
+// mp4Duration reads the duration of an MP4 file from its movie header (moov.mvhd) atom.
+func mp4Duration(path string) (time.Duration, error) {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile(songFile)
+	info, err := songFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	moov, moovSize, err := findMp4Atom(songFile, 0, info.Size(), "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, mvhdSize, err := findMp4Atom(songFile, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, minInt(int(mvhdSize), 32))
+	if _, err := songFile.ReadAt(header, mvhd); err != nil {
+		return 0, err
+	}
+	var timescale, duration uint64
+	if len(header) >= 32 && header[0] == 1 { // version 1 has 64 bit times
+		timescale, duration = uint64(binary.BigEndian.Uint32(header[20:])), binary.BigEndian.Uint64(header[24:])
+	} else if len(header) >= 20 {
+		timescale, duration = uint64(binary.BigEndian.Uint32(header[12:])), uint64(binary.BigEndian.Uint32(header[16:]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("MP4 file %q has an invalid movie header", path)
+	}
+	return time.Duration(duration) * time.Second / time.Duration(timescale), nil
+}
+
+// findMp4Atom finds an atom between start and end of a file, and returns the offset and size of its data.
+func findMp4Atom(songFile *os.File, start, end int64, name string) (int64, int64, error) {
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if _, err := songFile.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0: // to the end
+			size = end - offset
+		case 1: // 64 bit size
+			if _, err := songFile.ReadAt(header[8:], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			break
+		}
+		if string(header[4:8]) == name {
+			return offset + headerSize, size - headerSize, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("MP4 file %q has no %v atom", songFile.Name(), name)
+}
+
+const (
+	vorbisHeadMagic = "\x01vorbis"
+	// the last ogg page starts within this many bytes of the end, the largest possible page size
+	oggMaxPageSize = oggPageHeaderSize + 255 + 255*255
+)
+
+// oggDuration reads the duration of an ogg vorbis or opus file from the granule position of its last page,
+// without reading every page, see opusDuration.
+func oggDuration(path string) (time.Duration, error) {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile(songFile)
+	info, err := songFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	first := make([]byte, minInt(int(info.Size()), oggMaxPageSize))
+	if _, err := io.ReadFull(songFile, first); err != nil {
+		return 0, err
+	}
+	if len(first) < oggPageHeaderSize || string(first[:len(oggPageHeader)]) != oggPageHeader {
+		return 0, fmt.Errorf("%q is not an ogg file", path)
+	}
+	packet := first[oggPageHeaderSize+int(first[oggPageHeaderSize-1]):]
+	var sampleRate, preSkip int64
+	switch {
+	case bytes.HasPrefix(packet, []byte(opusHeadMagic)) && len(packet) >= 12:
+		sampleRate, preSkip = opusSampleRate, int64(binary.LittleEndian.Uint16(packet[10:12]))
+	case bytes.HasPrefix(packet, []byte(vorbisHeadMagic)) && len(packet) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(packet[12:16]))
+	}
+	if sampleRate == 0 {
+		return 0, fmt.Errorf("%q is not an ogg vorbis or opus file", path)
+	}
+
+	lastStart := maxInt64(0, info.Size()-oggMaxPageSize)
+	last := make([]byte, info.Size()-lastStart)
+	if _, err := songFile.ReadAt(last, lastStart); err != nil && err != io.EOF {
+		return 0, err
+	}
+	page := bytes.LastIndex(last, []byte(oggPageHeader))
+	if page < 0 || page+oggPageHeaderSize > len(last) {
+		return 0, fmt.Errorf("%q has no last ogg page", path)
+	}
+	granule := int64(binary.LittleEndian.Uint64(last[page+6:]))
+	return time.Duration(granule-preSkip) * time.Second / time.Duration(sampleRate), nil
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
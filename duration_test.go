@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/shawnsmithdev/tag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMp3 returns an MP3 file with an ID3v2 tag, a frame header followed by the frame data, and an ID3v1 tag.
+func testMp3(header []byte, frame []byte, size int) []byte {
+	id3 := testID3v23Tag(nil, 100)
+	audio := make([]byte, size)
+	copy(audio, append(append([]byte(nil), header...), frame...))
+	v1 := append([]byte("TAG"), make([]byte, id3v1Size-3)...)
+	return append(append(id3, audio...), v1...)
+}
+
+// testMp3Info returns the side information of a frame, followed by a Xing or VBRI header of a number of frames.
+func testMp3Info(sideInfo int, magic string, frames uint32) []byte {
+	info := append(make([]byte, sideInfo), magic...)
+	if magic == "VBRI" {
+		info = append(info, make([]byte, 18)...)
+		binary.BigEndian.PutUint32(info[sideInfo+14:], frames)
+		return info
+	}
+	info = append(info, 0, 0, 0, 1, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(info[sideInfo+8:], frames)
+	return info
+}
+
+// testMp4 returns an MP4 file with a movie header.
+func testMp4(mvhd []byte) []byte {
+	atom := func(name string, data ...[]byte) []byte {
+		body := bytes.Join(data, nil)
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header, uint32(8+len(body)))
+		copy(header[4:], name)
+		return append(header, body...)
+	}
+	return append(atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		atom("moov", atom("free", make([]byte, 4)), atom("mvhd", mvhd, make([]byte, 80)))...)
+}
+
+func TestSongDuration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flac := testFlac(nil, 10, make([]byte, 100))
+	binary.BigEndian.PutUint64(flac[sampleInfoStart:], 44100<<44|1<<41|15<<36|44100*30+22050)
+
+	mvhd0 := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd0[12:], 600)
+	binary.BigEndian.PutUint32(mvhd0[16:], 600*185)
+	mvhd1 := make([]byte, 32)
+	mvhd1[0] = 1
+	binary.BigEndian.PutUint32(mvhd1[20:], 44100)
+	binary.BigEndian.PutUint64(mvhd1[24:], 44100*3*3600)
+
+	vorbisHead := append([]byte(vorbisHeadMagic), 0, 0, 0, 0, 2, 0x44, 0xAC, 0, 0)
+
+	// MPEG-1 layer 3 128 kbps 44.1 kHz stereo, and MPEG-2 layer 3 64 kbps 22.05 kHz mono frame headers
+	mpeg1, mpeg2 := []byte{0xFF, 0xFB, 0x90, 0x00}, []byte{0xFF, 0xF3, 0x80, 0xC0}
+	tests := []struct {
+		name     string
+		fileType tag.FileType
+		file     []byte
+		want     time.Duration
+	}{
+		{name: "FLAC", fileType: tag.FLAC, file: flac, want: 30500 * time.Millisecond},
+		{name: "constant bitrate MP3", fileType: tag.MP3, file: testMp3(mpeg1, nil, 32000), want: 2 * time.Second},
+		{name: "MP3 after garbage", fileType: tag.MP3, file: testMp3(append([]byte{0xFF, 0, 0}, mpeg1...), nil, 16003),
+			want: time.Second},
+		{name: "MPEG-1 Xing header", fileType: tag.MP3, file: testMp3(mpeg1, testMp3Info(32, "Xing", 1000), 1000),
+			want: 1000 * 1152 * time.Second / 44100},
+		{name: "MPEG-1 Info header", fileType: tag.MP3, file: testMp3(mpeg1, testMp3Info(32, "Info", 1000), 1000),
+			want: 1000 * 1152 * time.Second / 44100},
+		{name: "MPEG-1 VBRI header", fileType: tag.MP3, file: testMp3(mpeg1, testMp3Info(32, "VBRI", 500), 1000),
+			want: 500 * 1152 * time.Second / 44100},
+		{name: "MPEG-2 mono Xing header", fileType: tag.MP3, file: testMp3(mpeg2, testMp3Info(9, "Xing", 1000), 1000),
+			want: 1000 * 576 * time.Second / 22050},
+		{name: "MP4", fileType: tag.M4A, file: testMp4(mvhd0), want: 185 * time.Second},
+		{name: "MP4 64 bit times", fileType: tag.ALAC, file: testMp4(mvhd1), want: 3 * time.Hour},
+		{name: "ogg opus", fileType: OPUS, file: bytes.Join([][]byte{
+			testOggPage(0, 0, testOpusHead(312)),
+			testOggPage(1, 0, testOpusTags("TITLE=Song")),
+			testOggPage(2, 48000+312, make([]byte, 300)),
+			testOggPage(3, 48000*3+312, make([]byte, 300)),
+		}, nil), want: 3 * time.Second},
+		{name: "ogg vorbis", fileType: tag.OGG, file: bytes.Join([][]byte{
+			testOggPage(0, 0, vorbisHead),
+			testOggPage(1, 44100*2, make([]byte, 300)),
+		}, nil), want: 2 * time.Second},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "song")
+		if err := ioutil.WriteFile(path, test.file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := songDuration(path, test.fileType)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got duration %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	for _, file := range [][]byte{testMp3(nil, nil, 1000), testMp3([]byte{0xFF, 0xFF, 0xF0, 0}, nil, 1000)} {
+		path := filepath.Join(dir, "song")
+		if err := ioutil.WriteFile(path, file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := songDuration(path, tag.MP3); err == nil {
+			t.Errorf("MP3 without frames has duration %v", got)
+		}
+	}
+}
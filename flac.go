@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -49,3 +54,284 @@ func flacMd5(songFile *os.File) (songHash, error) {
 	// all zero (not set)
 	return buffer, fmt.Errorf("FLAC file %q has no MD5 checksum in its STREAMINFO block\n", songFile.Name())
 }
+
+// STREAMINFO sample rate (20 bits), channels (3), bits per sample (5) and total samples (36) are packed
+// into the 8 bytes just before the MD5 hash.
+const sampleInfoStart = hashStart - 8
+
+// flacDuration reads the playback duration of a FLAC file from its STREAMINFO block.
+func flacDuration(path string) (time.Duration, error) {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile(songFile)
+
+	var header [len(flacHeader)]byte
+	if _, err := songFile.ReadAt(header[:], 0); err != nil {
+		return 0, err
+	} else if flacHeader != string(header[:]) {
+		return 0, fmt.Errorf("not a flac file")
+	}
+
+	var info [8]byte
+	if _, err := songFile.ReadAt(info[:], sampleInfoStart); err != nil {
+		return 0, err
+	}
+	packed := binary.BigEndian.Uint64(info[:])
+	sampleRate := packed >> 44
+	totalSamples := packed & (1<<36 - 1)
+	if sampleRate == 0 || totalSamples == 0 {
+		return 0, fmt.Errorf("FLAC file %q has unknown duration in its STREAMINFO block", path)
+	}
+	seconds := float64(totalSamples) / float64(sampleRate)
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+const (
+	// each metadata block header is last block flag (1 bit), block type (7 bits) and length (24 bits)
+	flacBlockHeaderSize    = 4
+	flacLastBlockFlag      = 0x80
+	flacPaddingBlock       = 1
+	flacVorbisCommentBlock = 4
+	flacMaxBlockSize       = 1<<24 - 1
+)
+
+// flacVorbisComments reads every value of each Vorbis comment of a FLAC file, keyed by lowercase name.
+// The tag library keeps only the last value of repeated comments, ex. ARTIST=Jay-Z and ARTIST=Beyoncé.
+func flacVorbisComments(path string) (map[string][]string, error) {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(songFile)
+
+	reader := bufio.NewReader(songFile)
+	var header [len(flacHeader)]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	} else if flacHeader != string(header[:]) {
+		return nil, fmt.Errorf("not a flac file")
+	}
+
+	for {
+		var blockHeader [flacBlockHeaderSize]byte
+		if _, err := io.ReadFull(reader, blockHeader[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(blockHeader[:]) & (1<<24 - 1))
+		if blockHeader[0]&^flacLastBlockFlag == flacVorbisCommentBlock {
+			block := make([]byte, length)
+			if _, err := io.ReadFull(reader, block); err != nil {
+				return nil, err
+			}
+			return parseVorbisComments(block)
+		}
+		if blockHeader[0]&flacLastBlockFlag != 0 {
+			return nil, nil
+		}
+		if _, err := reader.Discard(length); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseVorbisComments parses a little endian vendor string, comment count, and length prefixed NAME=value comments.
+func parseVorbisComments(block []byte) (map[string][]string, error) {
+	_, comments, err := splitVorbisComments(block)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for _, comment := range comments {
+		kv := strings.SplitN(comment, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := strings.ToLower(kv[0])
+		result[name] = append(result[name], kv[1])
+	}
+	return result, nil
+}
+
+// splitVorbisComments returns the vendor string and the NAME=value comments of a Vorbis comment block, in order.
+func splitVorbisComments(block []byte) (string, []string, error) {
+	next := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		length := binary.LittleEndian.Uint32(block)
+		if uint64(length) > uint64(len(block)-4) {
+			return nil, false
+		}
+		result := block[4 : 4+length]
+		block = block[4+length:]
+		return result, true
+	}
+	vendor, ok := next()
+	if !ok || len(block) < 4 {
+		return "", nil, fmt.Errorf("invalid vorbis comment block")
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return "", nil, fmt.Errorf("invalid vorbis comment block")
+		}
+		comments = append(comments, string(comment))
+	}
+	return string(vendor), comments, nil
+}
+
+// joinVorbisComments builds a Vorbis comment block, see splitVorbisComments.
+func joinVorbisComments(vendor string, comments []string) []byte {
+	var result []byte
+	var length [4]byte
+	appendString := func(s string) {
+		binary.LittleEndian.PutUint32(length[:], uint32(len(s)))
+		result = append(append(result, length[:]...), s...)
+	}
+	appendString(vendor)
+	binary.LittleEndian.PutUint32(length[:], uint32(len(comments)))
+	result = append(result, length[:]...)
+	for _, comment := range comments {
+		appendString(comment)
+	}
+	return result
+}
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+// writeFlacComments changes the Vorbis comments of a FLAC file, see tagEdit, leaving its audio frames untouched.
+// The metadata blocks are written in place if they fit in the space of the old ones and their padding,
+// otherwise the file is copied with new padding, see rewriteFile.
+func writeFlacComments(path string, edits []tagEdit) error {
+	songFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	blocks, audioStart, err := readFlacBlocks(bufio.NewReader(songFile))
+	closeFile(songFile)
+	if err != nil {
+		return err
+	}
+
+	vendor, comments, index := "discographic", []string(nil), -1
+	for i, block := range blocks {
+		if block.kind == flacVorbisCommentBlock {
+			index = i
+			if vendor, comments, err = splitVorbisComments(block.data); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if index < 0 { // after STREAMINFO, which is always first
+		index = 1
+		blocks = append(blocks[:1], append([]flacBlock{{kind: flacVorbisCommentBlock}}, blocks[1:]...)...)
+	}
+	blocks[index].data = joinVorbisComments(vendor, editVorbisComments(comments, edits))
+	if len(blocks[index].data) > flacMaxBlockSize {
+		return fmt.Errorf("vorbis comments of FLAC file %q are too large", path)
+	}
+
+	size := int64(len(flacHeader))
+	for _, block := range blocks {
+		size += flacBlockHeaderSize + int64(len(block.data))
+	}
+	padding := audioStart - size - flacBlockHeaderSize
+	inPlace := size == audioStart || (padding >= 0 && padding <= flacMaxBlockSize)
+	if !inPlace {
+		padding = tagPadding
+	}
+	if size != audioStart || !inPlace {
+		blocks = append(blocks, flacBlock{kind: flacPaddingBlock, data: make([]byte, padding)})
+	}
+
+	result := []byte(flacHeader)
+	for i, block := range blocks {
+		header := uint32(block.kind)<<24 | uint32(len(block.data))
+		if i == len(blocks)-1 {
+			header |= flacLastBlockFlag << 24
+		}
+		var blockHeader [flacBlockHeaderSize]byte
+		binary.BigEndian.PutUint32(blockHeader[:], header)
+		result = append(append(result, blockHeader[:]...), block.data...)
+	}
+	if inPlace {
+		return writeFileAt(path, result)
+	}
+	return rewriteFile(path, result, audioStart)
+}
+
+// readFlacBlocks reads the metadata blocks of a FLAC file, except padding, and returns where its audio frames start.
+func readFlacBlocks(reader *bufio.Reader) ([]flacBlock, int64, error) {
+	var header [len(flacHeader)]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, 0, err
+	} else if flacHeader != string(header[:]) {
+		return nil, 0, fmt.Errorf("not a flac file")
+	}
+	var blocks []flacBlock
+	audioStart := int64(len(flacHeader))
+	for last := false; !last; {
+		var blockHeader [flacBlockHeaderSize]byte
+		if _, err := io.ReadFull(reader, blockHeader[:]); err != nil {
+			return nil, 0, err
+		}
+		length := int(binary.BigEndian.Uint32(blockHeader[:]) & (1<<24 - 1))
+		last = blockHeader[0]&flacLastBlockFlag != 0
+		kind := blockHeader[0] &^ flacLastBlockFlag
+		audioStart += flacBlockHeaderSize + int64(length)
+		if kind == flacPaddingBlock {
+			if _, err := reader.Discard(length); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		block := flacBlock{kind: kind, data: make([]byte, length)}
+		if _, err := io.ReadFull(reader, block.data); err != nil {
+			return nil, 0, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, audioStart, nil
+}
+
+// editVorbisComments replaces the comments of each edited tag with a comment for each edited value, see tagEdit.
+func editVorbisComments(comments []string, edits []tagEdit) []string {
+	for _, edit := range edits {
+		names := writableTags[edit.field].vorbis
+		var firstValues []string
+		for _, comment := range comments {
+			if kv := strings.SplitN(comment, "=", 2); len(kv) == 2 && strings.EqualFold(names[0], kv[0]) {
+				firstValues = append(firstValues, kv[1])
+			}
+		}
+		var kept []string
+		var old string
+		for _, comment := range comments {
+			kv := strings.SplitN(comment, "=", 2)
+			if len(kv) == 2 && containsFold(names, kv[0]) {
+				if len(old) == 0 {
+					old = kv[1]
+				}
+				continue
+			}
+			if len(kv) == 2 && containsFold(vorbisRepeatTags[edit.field], kv[0]) && containsFold(firstValues, kv[1]) {
+				continue
+			}
+			kept = append(kept, comment)
+		}
+		comments = kept
+		for _, value := range edit.withTotal(old) {
+			comments = append(comments, names[0]+"="+value)
+		}
+	}
+	return comments
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditVorbisComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		comments []string
+		edits    []tagEdit
+		want     []string
+	}{
+		{
+			name:     "replaces every comment of the field",
+			comments: []string{"TITLE=Old", "DATE=1999", "YEAR=1999", "ALBUM=A"},
+			edits:    []tagEdit{{field: "date", values: []string{"2001-02-03"}}},
+			want:     []string{"TITLE=Old", "ALBUM=A", "DATE=2001-02-03"},
+		},
+		{
+			name:     "removes the field without values",
+			comments: []string{"TITLE=Old", "genre=Rock", "GENRE=Pop"},
+			edits:    []tagEdit{{field: "genre"}},
+			want:     []string{"TITLE=Old"},
+		},
+		{
+			name:     "writes each value",
+			comments: []string{"ARTIST=Jay-Z feat. Beyoncé"},
+			edits:    []tagEdit{{field: "artist", values: []string{"Jay-Z", "Beyoncé"}}},
+			want:     []string{"ARTIST=Jay-Z", "ARTIST=Beyoncé"},
+		},
+		{
+			name:     "keeps the track total",
+			comments: []string{"TRACKNUMBER=3/12"},
+			edits:    []tagEdit{{field: "track", values: []string{"4"}}},
+			want:     []string{"TRACKNUMBER=4/12"},
+		},
+		{
+			name:     "removes a performer that repeats the artist",
+			comments: []string{"ARTIST=Muse", "PERFORMER=muse"},
+			edits:    []tagEdit{{field: "artist", values: []string{"Matt Bellamy"}}},
+			want:     []string{"ARTIST=Matt Bellamy"},
+		},
+		{
+			name:     "keeps other performers",
+			comments: []string{"ARTIST=Beethoven", "PERFORMER=Martha Argerich (piano)", "PERFORMER=Beethoven"},
+			edits:    []tagEdit{{field: "artist", values: []string{"Ludwig van Beethoven"}}},
+			want:     []string{"PERFORMER=Martha Argerich (piano)", "ARTIST=Ludwig van Beethoven"},
+		},
+		{
+			name:     "keeps performers without an artist",
+			comments: []string{"PERFORMER=Martha Argerich"},
+			edits:    []tagEdit{{field: "artist", values: []string{"Martha Argerich"}}},
+			want:     []string{"PERFORMER=Martha Argerich", "ARTIST=Martha Argerich"},
+		},
+	}
+	for _, test := range tests {
+		got := editVorbisComments(test.comments, test.edits)
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// testFlac returns a FLAC file with a STREAMINFO block, any Vorbis comments, padding if not negative and audio.
+func testFlac(comments []string, padding int, audio []byte) []byte {
+	block := func(kind byte, last bool, data []byte) []byte {
+		header := []byte{kind, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}
+		if last {
+			header[0] |= flacLastBlockFlag
+		}
+		return append(header, data...)
+	}
+	streamInfo := make([]byte, 34)
+	for i := 0; i < md5Bytes; i++ {
+		streamInfo[hashStart-len(flacHeader)-flacBlockHeaderSize+i] = byte(i + 1)
+	}
+	result := append([]byte(flacHeader), block(0, comments == nil && padding < 0, streamInfo)...)
+	if comments != nil {
+		result = append(result, block(flacVorbisCommentBlock, padding < 0, joinVorbisComments("test", comments))...)
+	}
+	if padding >= 0 {
+		result = append(result, block(flacPaddingBlock, true, make([]byte, padding))...)
+	}
+	return append(result, audio...)
+}
+
+// testSum returns the hash of the audio of a file.
+func testSum(t *testing.T, path string, sum func(*os.File) (songHash, error)) songHash {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFile(f)
+	hash, err := sum(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestWriteFlacComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audio := bytes.Repeat([]byte{0xFF, 0xF8, 0x69, 0x08}, 256)
+	title := tagEdit{field: "title", values: []string{"New Title"}}
+	tests := []struct {
+		name        string
+		comments    []string
+		padding     int
+		edits       []tagEdit
+		want        map[string][]string
+		wantPadding int // -1 for no padding block
+		rewritten   bool
+	}{
+		{
+			name:     "fits in the padding",
+			comments: []string{"TITLE=Old", "ARTIST=Muse"}, padding: 100, edits: []tagEdit{title},
+			want:        map[string][]string{"title": {"New Title"}, "artist": {"Muse"}},
+			wantPadding: 94,
+		},
+		{
+			name:     "fits without padding",
+			comments: []string{"TITLE=Old"}, padding: 2, edits: []tagEdit{title},
+			want:        map[string][]string{"title": {"New Title"}},
+			wantPadding: -1,
+		},
+		{
+			name:     "no padding",
+			comments: []string{"TITLE=Old"}, padding: -1, edits: []tagEdit{title},
+			want:        map[string][]string{"title": {"New Title"}},
+			wantPadding: tagPadding, rewritten: true,
+		},
+		{
+			name:     "larger than the padding",
+			comments: []string{"TITLE=Old"}, padding: 100,
+			edits:       []tagEdit{{field: "album", values: []string{strings.Repeat("A", 200)}}},
+			want:        map[string][]string{"title": {"Old"}, "album": {strings.Repeat("A", 200)}},
+			wantPadding: tagPadding, rewritten: true,
+		},
+		{
+			name:    "adds vorbis comments",
+			padding: 100, edits: []tagEdit{title},
+			want:        map[string][]string{"title": {"New Title"}},
+			wantPadding: 57,
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "song.flac")
+		original := testFlac(test.comments, test.padding, audio)
+		if err := ioutil.WriteFile(path, original, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash := testSum(t, path, flacMd5)
+		if err := writeFlacComments(path, test.edits); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := testSum(t, path, flacMd5); got != hash {
+			t.Errorf("%s: hash changed from %v to %v", test.name, hash, got)
+		}
+		written, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten := len(written) != len(original); rewritten != test.rewritten {
+			t.Errorf("%s: file size changed from %d to %d", test.name, len(original), len(written))
+		}
+		if !bytes.HasSuffix(written, audio) || !bytes.Equal(written[:hashStart+md5Bytes], original[:hashStart+md5Bytes]) {
+			t.Errorf("%s: audio or STREAMINFO changed", test.name)
+		}
+		blocks, audioStart, err := readFlacBlocks(bufio.NewReader(bytes.NewReader(written)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if audioStart != int64(len(written)-len(audio)) {
+			t.Errorf("%s: audio starts at %d, want %d", test.name, audioStart, len(written)-len(audio))
+		}
+		padding := audioStart - int64(len(flacHeader)) - flacBlockHeaderSize
+		for _, block := range blocks {
+			padding -= flacBlockHeaderSize + int64(len(block.data))
+		}
+		if padding < 0 { // no padding block
+			padding = -1
+		}
+		if padding != int64(test.wantPadding) {
+			t.Errorf("%s: got %d bytes of padding, want %d", test.name, padding, test.wantPadding)
+		}
+		comments, err := flacVorbisComments(path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(comments) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got comments %v, want %v", test.name, comments, test.want)
+		}
+	}
+}
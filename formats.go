@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// how much the total durations of two versions of an album may differ, per song of the longer version,
+// for them to be the same release, see sameTracks
+const versionDurationTolerance = 2.0 // seconds
+
+// fileTypes are the song file types that can be named in a format preference
+var fileTypes = []tag.FileType{tag.FLAC, tag.ALAC, tag.M4A, tag.M4B, tag.M4P, tag.MP3, tag.OGG, OPUS, tag.DSF}
+
+// parseFormatPreference parses comma separated file types, most preferred first, ex. "flac,alac,m4a,mp3"
+func parseFormatPreference(formats string) ([]tag.FileType, error) {
+	var result []tag.FileType
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToUpper(strings.TrimSpace(format))
+		if len(format) == 0 {
+			continue
+		}
+		found := false
+		for _, fileType := range fileTypes {
+			found = found || format == string(fileType)
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown file type %q in format preference, must be one of %v", format, fileTypes)
+		}
+		result = append(result, tag.FileType(format))
+	}
+	return result, nil
+}
+
+// formatRank returns the position of a file type in a format preference, with file types not in it ranked last.
+func formatRank(formats []tag.FileType, fileType tag.FileType) int {
+	for i, format := range formats {
+		if format == fileType {
+			return i
+		}
+	}
+	return len(formats)
+}
+
+// albumFormat returns the most common file type of the songs of an album, the least by name if tied.
+func albumFormat(songs []*Song) tag.FileType {
+	counts := make(map[tag.FileType]int)
+	var result tag.FileType
+	for _, song := range songs {
+		counts[song.FileType]++
+		count, best := counts[song.FileType], counts[result]
+		if count > best || (count == best && song.FileType < result) {
+			result = song.FileType
+		}
+	}
+	return result
+}
+
+// preferFormats keeps only the preferred version of each album that is in the library in more than one format,
+// ex. a FLAC rip and an older MP3 rip, removing the others from albums, which are grouped by album key.
+// Versions of an album have the same album artists, name, ignoring case and diacritics, and year, see albumYear,
+// different formats, see albumFormat, and the same tracks, see sameTracks. Albums with songs in more than one
+// format that repeat a disc and track number are first split into a version for each format.
+// Versions in the same format as the preferred one are kept, as other releases.
+// It returns the versions removed, sorted by track, by the album key of the version kept in their place.
+// Nothing is removed if there is no format preference.
+func preferFormats(albums map[string][]*Song, args collectionArgs) map[string][][]*Song {
+	if len(args.formats) == 0 {
+		return nil
+	}
+	var mixed []string
+	for albumKey, songs := range albums {
+		if hasRepeatedTracks(songs) {
+			mixed = append(mixed, albumKey)
+		}
+	}
+	for _, albumKey := range mixed {
+		songs := albums[albumKey]
+		delete(albums, albumKey)
+		for _, song := range songs {
+			key := albumKey + "\x00" + string(song.FileType)
+			albums[key] = append(albums[key], song)
+		}
+	}
+
+	versions := make(map[string][]string) // album artists and name to album keys
+	for albumKey, songs := range albums {
+		artist := artistKey(args.variousArtists)
+		if !isCompilation(songs) {
+			var artists []string
+			for _, name := range albumArtists(songs) {
+				artists = append(artists, args.aliases.key(name))
+			}
+			sort.Strings(artists)
+			artist = strings.Join(artists, "\x00")
+		}
+		key := artist + "\x00\x00" + foldText(songs[0].Album) + "\x00" + strconv.Itoa(albumYear(songs))
+		versions[key] = append(versions[key], albumKey)
+	}
+
+	result := make(map[string][][]*Song)
+	for _, albumKeys := range versions {
+		if len(albumKeys) < 2 {
+			continue
+		}
+		formats := make(map[string]tag.FileType)
+		for _, albumKey := range albumKeys {
+			formats[albumKey] = albumFormat(albums[albumKey])
+		}
+		sort.Slice(albumKeys, func(i, j int) bool {
+			formatI, formatJ := formats[albumKeys[i]], formats[albumKeys[j]]
+			rankI, rankJ := formatRank(args.formats, formatI), formatRank(args.formats, formatJ)
+			if rankI != rankJ {
+				return rankI < rankJ
+			}
+			if formatI != formatJ {
+				return formatI < formatJ
+			}
+			return albumKeys[i] < albumKeys[j]
+		})
+		preferred := albumKeys[0]
+		for _, albumKey := range albumKeys[1:] {
+			if formats[albumKey] == formats[preferred] || !sameTracks(albums[albumKey], albums[preferred]) {
+				continue
+			}
+			songs := albums[albumKey]
+			sort.Slice(songs, compareSongTrack(songs))
+			result[preferred] = append(result[preferred], songs)
+			delete(albums, albumKey)
+		}
+	}
+	return result
+}
+
+// albumYear returns the most common year of the dates of the songs of an album, the earliest if tied,
+// or zero if none has a date.
+func albumYear(songs []*Song) int {
+	counts := make(map[int]int)
+	result := 0
+	for _, song := range songs {
+		date, ok := parseDate(song.Date)
+		if !ok {
+			continue
+		}
+		counts[date.Year]++
+		count, best := counts[date.Year], counts[result]
+		if count > best || (count == best && date.Year < result) {
+			result = date.Year
+		}
+	}
+	return result
+}
+
+// sameTracks returns true if two versions of an album have the same number of songs, or the same total duration
+// within versionDurationTolerance, ex. when one of them has a hidden track split into a song of its own.
+// Versions that differ, ex. a deluxe edition, are other releases.
+func sameTracks(songs, other []*Song) bool {
+	if len(songs) == len(other) {
+		return true
+	}
+	total := func(songs []*Song) float64 {
+		result := 0.0
+		for _, song := range songs {
+			if song.Duration == 0 {
+				return 0
+			}
+			result += song.Duration
+		}
+		return result
+	}
+	duration, otherDuration := total(songs), total(other)
+	tolerance := versionDurationTolerance * float64(maxInt(len(songs), len(other)))
+	return duration > 0 && otherDuration > 0 && math.Abs(duration-otherDuration) <= tolerance
+}
+
+// hasRepeatedTracks returns true if songs of an album in different formats have the same disc and track number.
+func hasRepeatedTracks(songs []*Song) bool {
+	formats := make(map[[2]int]tag.FileType)
+	for _, song := range songs {
+		if song.Track == 0 {
+			continue
+		}
+		track := [2]int{song.Disc, song.Track}
+		if format, ok := formats[track]; ok && format != song.FileType {
+			return true
+		}
+		formats[track] = song.FileType
+	}
+	return false
+}
+
+// newAlternates returns a collection of each version of an album removed in favour of a preferred format,
+// see preferFormats, with the format of its songs, see newAlbumCollection.
+func newAlternates(versions [][]*Song, lib Library) []Collection {
+	var result []Collection
+	for _, songs := range versions {
+		alternate := newAlbumCollection(songs, lib)
+		alternate.Format = albumFormat(songs)
+		result = append(result, alternate)
+	}
+	return result
+}
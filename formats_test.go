@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPreferFormats(t *testing.T) {
+	album := func(fileType tag.FileType, date string, durations ...float64) []*Song {
+		var songs []*Song
+		for i, duration := range durations {
+			songs = append(songs, &Song{
+				Title:       fmt.Sprint("Song ", i+1),
+				Album:       "Absolution",
+				AlbumArtist: "Muse",
+				Track:       i + 1,
+				Date:        date,
+				FileType:    fileType,
+				Duration:    duration,
+			})
+		}
+		return songs
+	}
+	tests := []struct {
+		name     string
+		versions map[string][]*Song
+		want     map[string][]string // album keys kept to the album keys of their alternates
+	}{
+		{
+			name: "same tracks",
+			versions: map[string][]*Song{
+				"flac": album(tag.FLAC, "2003", 200, 300),
+				"mp3":  album(tag.MP3, "2003-09-15", 200.5, 300.5),
+				"m4a":  album(tag.M4A, "2003", 0, 0),
+			},
+			want: map[string][]string{"flac": {"m4a", "mp3"}},
+		},
+		{
+			name: "other years",
+			versions: map[string][]*Song{
+				"flac": album(tag.FLAC, "2023", 200, 300),
+				"mp3":  album(tag.MP3, "2003", 200, 300),
+			},
+			want: map[string][]string{"flac": nil, "mp3": nil},
+		},
+		{
+			name: "same total duration",
+			versions: map[string][]*Song{
+				"flac": album(tag.FLAC, "2003", 200, 300, 100),
+				"mp3":  album(tag.MP3, "2003", 200, 401),
+			},
+			want: map[string][]string{"flac": {"mp3"}},
+		},
+		{
+			name: "deluxe edition",
+			versions: map[string][]*Song{
+				"flac": album(tag.FLAC, "2003", 200, 300, 100),
+				"mp3":  album(tag.MP3, "2003", 200, 300),
+			},
+			want: map[string][]string{"flac": nil, "mp3": nil},
+		},
+		{
+			name: "unknown durations",
+			versions: map[string][]*Song{
+				"flac": album(tag.FLAC, "2003", 200, 300, 100),
+				"mp3":  album(tag.MP3, "2003", 0, 0),
+			},
+			want: map[string][]string{"flac": nil, "mp3": nil},
+		},
+	}
+	args := collectionArgs{variousArtists: defaultVariousArtists, formats: []tag.FileType{tag.FLAC, tag.MP3}}
+	for _, test := range tests {
+		albums := make(map[string][]*Song)
+		keys := make(map[*Song]string)
+		for albumKey, songs := range test.versions {
+			albums[albumKey] = songs
+			keys[songs[0]] = albumKey
+		}
+		alternates := preferFormats(albums, args)
+		if len(albums) != len(test.want) {
+			t.Errorf("%s: got %d albums, want %d", test.name, len(albums), len(test.want))
+		}
+		for albumKey, want := range test.want {
+			if _, ok := albums[albumKey]; !ok {
+				t.Errorf("%s: album %q was removed", test.name, albumKey)
+			}
+			var got []string
+			for _, songs := range alternates[albumKey] {
+				got = append(got, keys[songs[0]])
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s: %q has alternates %v, want %v", test.name, albumKey, got, want)
+			}
+		}
+	}
+}
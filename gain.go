@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// loudness that gains are normalized to, in LUFS, that of ReplayGain 2.0, or 89 dB SPL in ReplayGain 1.0
+	replayGainReference = -18.0
+	// loudness of opus R128_*_GAIN tags, in LUFS, that of EBU R128
+	r128Reference = -23.0
+	// ReplayGain 1.0 references in dB SPL are this much louder than the same reference in LUFS
+	splToLufs = 107.0
+)
+
+// Raw tag names of gain metadata, see the tag name lists in tags.go.
+// MP4 files have ReplayGain in custom atoms, or iTunes Sound Check normalization in an iTunNORM atom,
+// which MP3 files may have as a comment.
+var (
+	trackGainTags         = []string{"replaygain_track_gain", "txxx:replaygain_track_gain"}
+	trackPeakTags         = []string{"replaygain_track_peak", "txxx:replaygain_track_peak"}
+	albumGainTags         = []string{"replaygain_album_gain", "txxx:replaygain_album_gain"}
+	albumPeakTags         = []string{"replaygain_album_peak", "txxx:replaygain_album_peak"}
+	referenceLoudnessTags = []string{"replaygain_reference_loudness", "txxx:replaygain_reference_loudness"}
+	r128TrackGainTags     = []string{"r128_track_gain"}
+	r128AlbumGainTags     = []string{"r128_album_gain"}
+	iTunesNormTags        = []string{"itunnorm", "comm:itunnorm"}
+)
+
+// ReplayGain is the loudness normalization of a song, as gains in dB that bring the song, or its album,
+// to the ReplayGain 2.0 reference loudness of -18 LUFS, and peak sample amplitudes, where 1 is full scale.
+// Gains not tagged are nil, and peaks not tagged are zero.
+type ReplayGain struct {
+	TrackGain *float64 `json:"track_gain,omitempty"` // ex. -6.5
+	TrackPeak float64  `json:"track_peak,omitempty"` // ex. 0.988
+	AlbumGain *float64 `json:"album_gain,omitempty"`
+	AlbumPeak float64  `json:"album_peak,omitempty"`
+}
+
+// newReplayGain reads the gain metadata of a song, or returns nil if it has none.
+// ReplayGain tags are preferred, adjusted for any reference loudness other than -18 LUFS, ex. 83 dB or -23 LUFS,
+// then opus R128 gains, in Q7.8 fixed point dB relative to -23 LUFS, then iTunes Sound Check, which has no album gain.
+func newReplayGain(raw rawTags) *ReplayGain {
+	result := &ReplayGain{}
+	adjust := 0.0
+	if reference, ok := parseReferenceLoudness(raw.first(referenceLoudnessTags)); ok {
+		adjust = replayGainReference - reference
+	}
+	if gain, ok := parseGain(raw.first(trackGainTags)); ok {
+		result.TrackGain = roundedGain(gain + adjust)
+	} else if gain, ok := parseR128Gain(raw.first(r128TrackGainTags)); ok {
+		result.TrackGain = roundedGain(gain + replayGainReference - r128Reference)
+	}
+	if gain, ok := parseGain(raw.first(albumGainTags)); ok {
+		result.AlbumGain = roundedGain(gain + adjust)
+	} else if gain, ok := parseR128Gain(raw.first(r128AlbumGainTags)); ok {
+		result.AlbumGain = roundedGain(gain + replayGainReference - r128Reference)
+	}
+	result.TrackPeak, _ = parsePeak(raw.first(trackPeakTags))
+	result.AlbumPeak, _ = parsePeak(raw.first(albumPeakTags))
+	if result.TrackGain == nil {
+		if gain, peak, ok := parseITunesNorm(raw.first(iTunesNormTags)); ok {
+			result.TrackGain = roundedGain(gain)
+			if result.TrackPeak == 0 {
+				result.TrackPeak = peak
+			}
+		}
+	}
+	if result.TrackGain == nil && result.AlbumGain == nil && result.TrackPeak == 0 && result.AlbumPeak == 0 {
+		return nil
+	}
+	return result
+}
+
+// parseGain parses a ReplayGain gain, ex. "-6.50 dB" or "+1.2 dB"
+func parseGain(value string) (float64, bool) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	gain, err := strconv.ParseFloat(strings.TrimPrefix(fields[0], "+"), 64)
+	return gain, err == nil && !math.IsNaN(gain) && !math.IsInf(gain, 0)
+}
+
+// parsePeak parses a ReplayGain peak, ex. "0.988235"
+func parsePeak(value string) (float64, bool) {
+	peak, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || peak <= 0 || math.IsInf(peak, 0) || math.IsNaN(peak) {
+		return 0, false
+	}
+	return math.Round(peak*1e6) / 1e6, true
+}
+
+// parseReferenceLoudness parses a ReplayGain reference loudness as LUFS, ex. "89.0 dB" is -18, "-18.00 LUFS" is -18.
+// Positive values are ReplayGain 1.0 dB SPL.
+func parseReferenceLoudness(value string) (float64, bool) {
+	reference, ok := parseGain(value)
+	if ok && reference > 0 {
+		reference -= splToLufs
+	}
+	return reference, ok
+}
+
+// parseR128Gain parses an opus R128 gain, a Q7.8 fixed point number of dB, ex. "-1536" is -6 dB
+func parseR128Gain(value string) (float64, bool) {
+	gain, err := strconv.ParseInt(strings.TrimSpace(value), 10, 16)
+	return float64(gain) / 256, err == nil
+}
+
+// parseITunesNorm parses an iTunes Sound Check iTunNORM value of 10 hexadecimal numbers,
+// of which the first two are the loudness of each channel in thousandths of the reference loudness,
+// and the seventh and eighth are the peak sample of each channel, ex.
+// " 00000301 000002A7 00001B6A 000017F6 0001F6D1 0001F6D1 00007F9E 00007FFF 0000BB80 0000BB80"
+func parseITunesNorm(value string) (gain, peak float64, ok bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 {
+		return 0, 0, false
+	}
+	var numbers [8]uint64
+	for i := range numbers {
+		number, err := strconv.ParseUint(fields[i], 16, 32)
+		if err != nil {
+			return 0, 0, false
+		}
+		numbers[i] = number
+	}
+	loudness := math.Max(float64(numbers[0]), float64(numbers[1]))
+	if loudness == 0 {
+		return 0, 0, false
+	}
+	gain = -10 * math.Log10(loudness/1000)
+	peak = math.Round(math.Max(float64(numbers[6]), float64(numbers[7]))/32768*1e6) / 1e6
+	return gain, peak, true
+}
+
+func roundedGain(gain float64) *float64 {
+	result := math.Round(gain*100) / 100
+	return &result
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewReplayGain(t *testing.T) {
+	format := func(gain *ReplayGain) string {
+		if gain == nil {
+			return "<nil>"
+		}
+		value := func(v *float64) string {
+			if v == nil {
+				return "<nil>"
+			}
+			return fmt.Sprint(*v)
+		}
+		return fmt.Sprintf("track %v peak %v, album %v peak %v",
+			value(gain.TrackGain), gain.TrackPeak, value(gain.AlbumGain), gain.AlbumPeak)
+	}
+	tests := []struct {
+		name string
+		raw  rawTags
+		want string
+	}{
+		{name: "no gain", raw: rawTags{"title": "Song"}, want: "<nil>"},
+		{
+			name: "replaygain",
+			raw: rawTags{"replaygain_track_gain": "-6.50 dB", "replaygain_track_peak": "0.988235",
+				"replaygain_album_gain": "+1.2 dB", "replaygain_album_peak": "1.0000001"},
+			want: "track -6.5 peak 0.988235, album 1.2 peak 1",
+		},
+		{
+			name: "ID3 user defined frames",
+			raw:  rawTags{"txxx:replaygain_track_gain": "-3 dB"},
+			want: "track -3 peak 0, album <nil> peak 0",
+		},
+		{
+			name: "replaygain 1.0 reference loudness",
+			raw:  rawTags{"replaygain_track_gain": "-6.5 dB", "replaygain_reference_loudness": "83.0 dB"},
+			want: "track -0.5 peak 0, album <nil> peak 0",
+		},
+		{
+			name: "EBU R128 reference loudness",
+			raw:  rawTags{"replaygain_album_gain": "-1 dB", "replaygain_reference_loudness": "-23 LUFS"},
+			want: "track <nil> peak 0, album 4 peak 0",
+		},
+		{
+			name: "opus R128 gains",
+			raw:  rawTags{"r128_track_gain": "-1536", "r128_album_gain": "256"},
+			want: "track -1 peak 0, album 6 peak 0",
+		},
+		{
+			name: "replaygain before R128 gains",
+			raw:  rawTags{"replaygain_track_gain": "-2 dB", "r128_track_gain": "-1536"},
+			want: "track -2 peak 0, album <nil> peak 0",
+		},
+		{
+			name: "iTunes Sound Check",
+			raw: rawTags{"itunnorm": " 000007D0 000003E8 00001B6A 000017F6 0001F6D1 0001F6D1 00007F9E 00004000 " +
+				"0000BB80 0000BB80"},
+			want: "track -3.01 peak 0.997009, album <nil> peak 0",
+		},
+		{
+			name: "Sound Check does not replace replaygain",
+			raw:  rawTags{"replaygain_track_gain": "-2 dB", "comm:itunnorm": " 00002710 00002710 0 0 0 0 0 0 0 0"},
+			want: "track -2 peak 0, album <nil> peak 0",
+		},
+		{
+			name: "invalid values",
+			raw: rawTags{"replaygain_track_gain": "loud", "replaygain_track_peak": "-1", "r128_album_gain": "99999",
+				"itunnorm": "0 0 0 0 0 0 0 0 0 0"},
+			want: "<nil>",
+		},
+	}
+	for _, test := range tests {
+		if got := format(newReplayGain(test.raw)); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseITunesNorm(t *testing.T) {
+	tests := []struct {
+		value      string
+		gain, peak float64
+		ok         bool
+	}{
+		{value: " 00002710 00001388 0 0 0 0 00008000 00004000 0 0", gain: -10, peak: 1, ok: true},
+		{value: " 00000064 00000064 0 0 0 0 00004000 00004000 0 0", gain: 10, peak: 0.5, ok: true},
+		{value: " 00000064 00000064 0 0 0 0 00004000", ok: false},
+		{value: " 00000064 0000006x 0 0 0 0 00004000 00004000 0 0", ok: false},
+		{value: "", ok: false},
+	}
+	for _, test := range tests {
+		gain, peak, ok := parseITunesNorm(test.value)
+		if ok != test.ok || (ok && (roundedGain(gain) == nil || *roundedGain(gain) != test.gain || peak != test.peak)) {
+			t.Errorf("parseITunesNorm(%q) = %v, %v, %v, want %v, %v, %v",
+				test.value, gain, peak, ok, test.gain, test.peak, test.ok)
+		}
+	}
+}
@@ -5,4 +5,5 @@ require (
 	github.com/dimfeld/httptreemux/v5 v5.0.2
 	github.com/shawnsmithdev/tag v0.0.0-20190204050253-a3f85946f98e
 	golang.org/x/sync v0.0.0-20190412183630-56d357773e84
+	golang.org/x/text v0.3.2
 )
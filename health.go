@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// albumHealth is the problems of an album, see libraryHealth.
+type albumHealth struct {
+	Name     string         `json:"name"`
+	Artist   string         `json:"artist"`
+	Problems []albumProblem `json:"problems"`
+}
+
+// albumProblem is a problem of an album, with the songs that cause it, if any.
+type albumProblem struct {
+	Problem string        `json:"problem"`
+	Songs   []problemSong `json:"songs,omitempty"`
+}
+
+type problemSong struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+}
+
+// libraryHealth finds albums with gaps in their track or disc numbers, songs without a track number,
+// album artist, date or file type that differ across songs, or songs without art, sorted by artist and name.
+// Compilations are by the various artists name. Albums are grouped by healthAlbumKey.
+func libraryHealth(lib Library, args collectionArgs) []albumHealth {
+	albums := make(map[string][]*Song)
+	forbidErr(lib.songs(func(song *Song) error {
+		key := healthAlbumKey(song)
+		albums[key] = append(albums[key], song)
+		return nil
+	}))
+	var result []albumHealth
+	for _, songs := range albums {
+		sort.Slice(songs, compareSongTrack(songs))
+		problems := trackGaps(songs)
+		problems = appendProblem(problems, "no track number", songs, func(song *Song) bool {
+			return song.Track == 0
+		})
+		problems = appendDifferences(problems, "album artist", songs, func(song *Song) string {
+			return song.AlbumArtist
+		})
+		problems = appendDifferences(problems, "date", songs, func(song *Song) string {
+			return song.Date
+		})
+		problems = appendDifferences(problems, "file type", songs, func(song *Song) string {
+			return string(song.FileType)
+		})
+		problems = appendProblem(problems, "no art", songs, func(song *Song) bool {
+			return len(song.Art) == 0
+		})
+		if len(problems) == 0 {
+			continue
+		}
+		artist := args.variousArtists
+		if !isCompilation(songs) {
+			artist = strings.Join(albumArtists(songs), multiValueSeparator)
+		}
+		result = append(result, albumHealth{Name: songs[0].Album, Artist: artist, Problems: problems})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Artist != result[j].Artist {
+			return result[i].Artist < result[j].Artist
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// healthAlbumKey returns the MusicBrainz release id of the album of a song, or else its folder and album name,
+// ignoring case. Unlike songAlbumKey, songs of an album that differ by album artist or date are not split,
+// so that the differences are reported.
+func healthAlbumKey(song *Song) string {
+	if len(song.MusicBrainzAlbumID) > 0 {
+		return "mbid:" + strings.ToLower(song.MusicBrainzAlbumID)
+	}
+	return songAlbumFolder(song) + "\x00" + strings.ToLower(song.Album)
+}
+
+// trackGaps finds missing discs, and missing tracks on each disc, up to the highest or total number tagged.
+func trackGaps(songs []*Song) []albumProblem {
+	tracks := make(map[int]map[int]bool) // disc to tracks
+	totalTracks := make(map[int]int)     // disc to total
+	discs, totalDiscs := make(map[int]bool), 0
+	for _, song := range songs {
+		if song.Disc > 0 {
+			discs[song.Disc] = true
+			totalDiscs = maxInt(totalDiscs, maxInt(song.Disc, song.TotalDiscs))
+		}
+		if song.Track == 0 {
+			continue
+		}
+		if _, ok := tracks[song.Disc]; !ok {
+			tracks[song.Disc] = make(map[int]bool)
+		}
+		tracks[song.Disc][song.Track] = true
+		totalTracks[song.Disc] = maxInt(totalTracks[song.Disc], maxInt(song.Track, song.TotalTracks))
+	}
+
+	var result []albumProblem
+	if missing := missingNumbers(discs, totalDiscs); len(missing) > 0 {
+		result = append(result, albumProblem{Problem: "missing discs " + missing})
+	}
+	var sortedDiscs []int
+	for disc := range tracks {
+		sortedDiscs = append(sortedDiscs, disc)
+	}
+	sort.Ints(sortedDiscs)
+	for _, disc := range sortedDiscs {
+		if missing := missingNumbers(tracks[disc], totalTracks[disc]); len(missing) > 0 {
+			problem := "missing tracks " + missing
+			if disc > 0 && totalDiscs > 1 {
+				problem += fmt.Sprintf(" on disc %v", disc)
+			}
+			result = append(result, albumProblem{Problem: problem})
+		}
+	}
+	return result
+}
+
+// missingNumbers returns the numbers from 1 to total that are not present, as comma separated ranges, ex. 3, 5-9
+func missingNumbers(present map[int]bool, total int) string {
+	var missing []string
+	for i := 1; i <= total; i++ {
+		if present[i] {
+			continue
+		}
+		first := i
+		for i < total && !present[i+1] {
+			i++
+		}
+		if i == first {
+			missing = append(missing, fmt.Sprint(i))
+		} else {
+			missing = append(missing, fmt.Sprintf("%v-%v", first, i))
+		}
+	}
+	return strings.Join(missing, ", ")
+}
+
+// appendProblem appends a problem if any songs have it.
+func appendProblem(problems []albumProblem, problem string, songs []*Song, has func(*Song) bool) []albumProblem {
+	result := problemSongs(songs, has)
+	if len(result) == 0 {
+		return problems
+	}
+	if len(result) < len(songs) {
+		problem = fmt.Sprintf("%v on %v of %v songs", problem, len(result), len(songs))
+	}
+	return append(problems, albumProblem{Problem: problem, Songs: result})
+}
+
+func problemSongs(songs []*Song, has func(*Song) bool) []problemSong {
+	var result []problemSong
+	for _, song := range songs {
+		if has(song) {
+			result = append(result, problemSong{Hash: song.Hash.String(), Path: song.Path})
+		}
+	}
+	return result
+}
+
+// appendDifferences appends a problem if songs have different values of a field,
+// ex. 2 different dates: "2001" (11 songs), "2002" (1 song)
+// The songs that cause it are those without the most common value.
+func appendDifferences(problems []albumProblem, field string, songs []*Song, value func(*Song) string) []albumProblem {
+	counts := make(map[string]int)
+	var values []string // in song order
+	for _, song := range songs {
+		v := value(song)
+		if counts[v] == 0 {
+			values = append(values, v)
+		}
+		counts[v]++
+	}
+	if len(values) < 2 {
+		return problems
+	}
+	common := values[0]
+	var described []string
+	for _, v := range values {
+		if counts[v] > counts[common] {
+			common = v
+		}
+		songCount := "songs"
+		if counts[v] == 1 {
+			songCount = "song"
+		}
+		described = append(described, fmt.Sprintf("%q (%v %v)", v, counts[v], songCount))
+	}
+	return append(problems, albumProblem{
+		Problem: fmt.Sprintf("%v different %vs: %v", len(values), field, strings.Join(described, ", ")),
+		Songs: problemSongs(songs, func(song *Song) bool {
+			return value(song) != common
+		}),
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLibraryHealth(t *testing.T) {
+	lib := newLibrary()
+	add := func(path, albumArtist, date string, track int) {
+		hash := songHash{byte(len(lib.SongMap) + 1)}
+		lib.SongMap[hash] = &Song{
+			Hash: hash, Path: path, Title: path, Album: "Absolution", Artist: "Muse", AlbumArtist: albumArtist,
+			Date: date, Track: track, TotalTracks: 3, FileType: "FLAC", Art: "art",
+		}
+	}
+	add("/music/Muse/Absolution/01.flac", "Muse", "2003", 1)
+	add("/music/Muse/Absolution/02.flac", "Muse feat. X", "2003", 2)
+	add("/music/Muse/Absolution/03.flac", "Muse", "2004", 3)
+	add("/music/Muse/Absolution (2023)/01.flac", "Muse", "2023", 1)
+	add("/music/Muse/Absolution (2023)/02.flac", "Muse", "2023", 2)
+	add("/music/Muse/Absolution (2023)/03.flac", "Muse", "2023", 3)
+
+	result := libraryHealth(lib, collectionArgs{variousArtists: defaultVariousArtists})
+	if len(result) != 1 {
+		t.Fatalf("got %d albums with problems, want 1: %+v", len(result), result)
+	}
+	var problems []string
+	for _, problem := range result[0].Problems {
+		problems = append(problems, problem.Problem)
+		if len(problem.Songs) != 1 {
+			t.Errorf("problem %q has songs %v, want 1", problem.Problem, problem.Songs)
+		}
+	}
+	want := []string{
+		`2 different album artists: "Muse" (2 songs), "Muse feat. X" (1 song)`,
+		`2 different dates: "2003" (2 songs), "2004" (1 song)`,
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems %q, want %q", problems, want)
+	}
+}
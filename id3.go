@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	id3HeaderSize      = 10
+	id3FrameHeaderSize = 10
+
+	id3UnsyncFlag            = 0x80 // tag header flag
+	id3ExtendedHeaderFlag    = 0x40 // tag header flag
+	id3FooterFlag            = 0x10 // ID3v2.4 tag header flag
+	id3FrameGroupFlag        = 0x40 // ID3v2.4 frame format flag
+	id3FrameUnsyncFlag       = 0x02 // ID3v2.4 frame format flag
+	id3FrameDataLengthFlag   = 0x01 // ID3v2.4 frame format flag
+	id3FrameCompressedFlag   = 0x08 // ID3v2.4 frame format flag
+	id3FrameEncryptedFlag    = 0x04 // ID3v2.4 frame format flag
+	id3v23FrameCompressFlag  = 0x80 // ID3v2.3 frame format flag
+	id3v23FrameEncryptedFlag = 0x40 // ID3v2.3 frame format flag
+	id3v23FrameGroupFlag     = 0x20 // ID3v2.3 frame format flag
+
+	id3EncodingISO8859 = 0
+	id3EncodingUTF16   = 1 // with byte order mark
+	id3EncodingUTF16BE = 2
+	id3EncodingUTF8    = 3
+)
+
+// id3Tag is an ID3v2 tag at the start of a file. Only ID3v2.3 and ID3v2.4 tags have frames.
+type id3Tag struct {
+	version byte
+	flags   byte
+	size    int64 // of the whole tag, including header, padding and any footer
+	frames  []id3Frame
+}
+
+// id3Frame is a frame of an ID3v2.3 or ID3v2.4 tag, with any unsynchronisation and data length indicator removed,
+// and its flags as in ID3v2.4. Compressed and encrypted frames are kept as is, and are not decoded.
+type id3Frame struct {
+	id     string
+	status byte // ID3v2.4 frame status flags
+	format byte // ID3v2.4 frame format flags
+	opaque bool // compressed or encrypted
+	body   []byte
+}
+
+// readID3Tag reads an ID3v2 tag at the start of a file, or returns nil if the file does not start with one.
+func readID3Tag(file io.Reader) (*id3Tag, error) {
+	var header [id3HeaderSize]byte
+	if _, err := io.ReadFull(file, header[:]); err != nil || string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+	result := &id3Tag{version: header[3], flags: header[5]}
+	result.size = id3HeaderSize + int64(syncsafe(header[6:10]))
+	if result.flags&id3FooterFlag != 0 && result.version == 4 {
+		result.size += id3HeaderSize
+	}
+	version, flags := result.version, result.flags
+	if version != 3 && version != 4 {
+		return result, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, int64(syncsafe(header[6:10]))))
+	if err != nil {
+		return nil, err
+	}
+	if flags&id3UnsyncFlag != 0 && version == 3 {
+		data = id3Resync(data)
+	}
+	if flags&id3ExtendedHeaderFlag != 0 && len(data) >= 4 {
+		if version == 3 {
+			data = data[minInt(len(data), 4+int(binary.BigEndian.Uint32(data))):]
+		} else {
+			data = data[minInt(len(data), int(syncsafe(data[:4]))):]
+		}
+	}
+
+	for len(data) >= id3FrameHeaderSize && data[0] != 0 {
+		frame := id3Frame{id: string(data[:4])}
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			size = int(syncsafe(data[4:8]))
+		}
+		status, format := data[8], data[9]
+		data = data[id3FrameHeaderSize:]
+		if size > len(data) {
+			break
+		}
+		frame.body = data[:size]
+		data = data[size:]
+
+		if version == 3 {
+			frame.status = status >> 1
+			frame.opaque = format&(id3v23FrameCompressFlag|id3v23FrameEncryptedFlag) != 0
+			if format&id3v23FrameGroupFlag != 0 {
+				frame.format = id3FrameGroupFlag
+			}
+		} else {
+			frame.status = status
+			frame.opaque = format&(id3FrameCompressedFlag|id3FrameEncryptedFlag) != 0
+			frame.format = format & id3FrameGroupFlag
+			if frame.opaque {
+				frame.format = format
+			} else {
+				if format&id3FrameDataLengthFlag != 0 && len(frame.body) >= 4 {
+					frame.body = frame.body[4:]
+				}
+				if format&id3FrameUnsyncFlag != 0 || flags&id3UnsyncFlag != 0 {
+					frame.body = id3Resync(frame.body)
+				}
+			}
+		}
+		result.frames = append(result.frames, frame)
+	}
+	return result, nil
+}
+
+// id3TextFrames reads the text frames (except TXXX) of an ID3v2.3 or ID3v2.4 tag at the start of a file,
+// keyed by frame id, with each null separated value.
+// The tag library joins null separated values together, so that the separate values are lost.
+// Returns nil if the file does not start with an ID3v2.3 or ID3v2.4 tag.
+func id3TextFrames(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(file)
+
+	tag, err := readID3Tag(file)
+	if err != nil || tag == nil || (tag.version != 3 && tag.version != 4) {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for _, frame := range tag.frames {
+		if frame.id[0] != 'T' || frame.id == "TXXX" || frame.opaque || len(frame.body) == 0 {
+			continue
+		}
+		result[frame.id] = append(result[frame.id], id3TextValues(frame.body[0], frame.body[1:])...)
+	}
+	return result, nil
+}
+
+// id3v1Size is the size of an ID3v1 tag at the end of a file
+const id3v1Size = 128
+
+// id3AudioSum returns a SHA1 hash of the audio of an MP3 file, after any ID3v2 tag and before the last 128 bytes,
+// where an ID3v1 tag would be, so that it does not change when tags do.
+// The tag library's sum also hashes the ID3v2 tag. Without an ID3v2 tag, the hashes are the same.
+func id3AudioSum(songFile *os.File) (songHash, error) {
+	// best effort file reset
+	defer func() {
+		_, _ = songFile.Seek(0, 0)
+	}()
+
+	var result songHash
+	info, err := songFile.Stat()
+	if err != nil {
+		return result, err
+	}
+	tag, err := readID3Tag(songFile)
+	if err != nil {
+		return result, err
+	}
+	start := int64(0)
+	if tag != nil {
+		start = tag.size
+	}
+	if start > info.Size()-id3v1Size {
+		return result, fmt.Errorf("MP3 file %q has no audio", songFile.Name())
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(songFile, start, info.Size()-id3v1Size-start)); err != nil {
+		return result, err
+	}
+	copy(result[:], h.Sum(nil))
+	return result, nil
+}
+
+// syncsafe decodes a 4 byte integer with 7 bits in each byte.
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0])<<21 | uint32(b[1])<<14 | uint32(b[2])<<7 | uint32(b[3])
+}
+
+// id3Resync removes the zero byte the unsynchronisation scheme inserts after every 0xFF byte.
+func id3Resync(b []byte) []byte {
+	result := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		result = append(result, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return result
+}
+
+// id3TextValues decodes the null separated values of a text frame.
+func id3TextValues(encoding byte, b []byte) []string {
+	var result []string
+	if encoding == id3EncodingUTF16 || encoding == id3EncodingUTF16BE {
+		bigEndian := true
+		var units []uint16
+		flush := func() {
+			result = append(result, string(utf16.Decode(units)))
+			units = nil
+			bigEndian = true
+		}
+		for i := 0; i+1 < len(b); i += 2 {
+			switch unit := uint16(b[i])<<8 | uint16(b[i+1]); {
+			case unit == 0:
+				flush()
+			case unit == 0xFEFF && len(units) == 0:
+			case unit == 0xFFFE && len(units) == 0:
+				bigEndian = false
+			case bigEndian:
+				units = append(units, unit)
+			default:
+				units = append(units, unit>>8|unit<<8)
+			}
+		}
+		flush()
+	} else {
+		for _, value := range strings.Split(string(b), "\x00") {
+			if encoding == id3EncodingISO8859 {
+				runes := make([]rune, len(value))
+				for i := 0; i < len(value); i++ {
+					runes[i] = rune(value[i])
+				}
+				value = string(runes)
+			}
+			result = append(result, value)
+		}
+	}
+	var values []string
+	for _, value := range result {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// writeID3Tag changes the text frames of the ID3v2 tag of an MP3 file, see tagEdit, leaving its audio frames untouched.
+// The tag is written as ID3v2.4, converting an ID3v2.3 tag or adding a tag if there is none.
+// It is written in place if it fits in the space of the old tag and its padding, otherwise the file is copied
+// with new padding, see rewriteFile.
+func writeID3Tag(path string, edits []tagEdit) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	tag, err := readID3Tag(bufio.NewReader(file))
+	closeFile(file)
+	if err != nil {
+		return err
+	}
+	if tag == nil {
+		tag = &id3Tag{version: 4}
+	}
+	switch {
+	case tag.version != 3 && tag.version != 4:
+		return fmt.Errorf("ID3v2.%d tags can not be written", tag.version)
+	case tag.flags&id3FooterFlag != 0:
+		return fmt.Errorf("ID3v2 tags with a footer can not be written")
+	}
+	for _, frame := range tag.frames {
+		if frame.opaque && tag.version == 3 {
+			return fmt.Errorf("ID3v2.3 tags with compressed or encrypted frames can not be written")
+		}
+	}
+	frames := tag.frames
+	if tag.version == 3 {
+		frames = upgradeID3Frames(frames)
+	}
+	frames = editID3Frames(frames, edits)
+
+	var body []byte
+	for _, frame := range frames {
+		var header [id3FrameHeaderSize]byte
+		copy(header[:4], frame.id)
+		putSyncsafe(header[4:8], uint32(len(frame.body)))
+		header[8], header[9] = frame.status, frame.format
+		body = append(append(body, header[:]...), frame.body...)
+	}
+	size := int64(id3HeaderSize + len(body))
+	inPlace := size <= tag.size
+	if inPlace {
+		size = tag.size
+	} else {
+		size += tagPadding
+	}
+	result := make([]byte, size)
+	copy(result, "ID3")
+	result[3] = 4
+	putSyncsafe(result[6:10], uint32(size-id3HeaderSize))
+	copy(result[id3HeaderSize:], body)
+	if inPlace {
+		return writeFileAt(path, result)
+	}
+	return rewriteFile(path, result, tag.size)
+}
+
+// upgradeID3Frames converts ID3v2.3 frames to ID3v2.4 frames: TYER, TDAT and TORY to TDRC and TDOR,
+// without the other frames removed from ID3v2.4.
+func upgradeID3Frames(frames []id3Frame) []id3Frame {
+	text := make(map[string]string)
+	for _, frame := range frames {
+		if !frame.opaque && len(frame.body) > 0 {
+			if values := id3TextValues(frame.body[0], frame.body[1:]); len(values) > 0 {
+				text[frame.id] = values[0]
+			}
+		}
+	}
+	var result []id3Frame
+	for _, frame := range frames {
+		switch frame.id {
+		case "TYER", "TDAT", "TIME", "TORY", "TRDA", "TSIZ":
+		default:
+			result = append(result, frame)
+		}
+	}
+	if date := text["TYER"]; len(date) > 0 && len(text["TDRC"]) == 0 {
+		if ddmm := text["TDAT"]; len(ddmm) == 4 {
+			date += "-" + ddmm[2:] + "-" + ddmm[:2]
+		}
+		result = append(result, newID3TextFrame("TDRC", []string{date}))
+	}
+	if year := text["TORY"]; len(year) > 0 && len(text["TDOR"]) == 0 {
+		result = append(result, newID3TextFrame("TDOR", []string{year}))
+	}
+	return result
+}
+
+// editID3Frames replaces the frames of each edited tag with a UTF-8 text frame of the edited values, see tagEdit.
+// User defined text frames are named TXXX:description.
+func editID3Frames(frames []id3Frame, edits []tagEdit) []id3Frame {
+	for _, edit := range edits {
+		names := writableTags[edit.field].id3
+		var kept []id3Frame
+		var old string
+		for _, frame := range frames {
+			if name := id3FrameName(frame); !frame.opaque && containsFold(names, name) {
+				if len(old) == 0 && len(frame.body) > 0 {
+					if values := id3TextValues(frame.body[0], frame.body[1:]); len(values) > 0 {
+						old = values[0]
+					}
+				}
+				continue
+			}
+			kept = append(kept, frame)
+		}
+		frames = kept
+		if values := edit.withTotal(old); len(values) > 0 {
+			frames = append(frames, newID3TextFrame(names[0], values))
+		}
+	}
+	return frames
+}
+
+// id3FrameName returns the frame id, or TXXX:description for user defined text frames.
+func id3FrameName(frame id3Frame) string {
+	if frame.id != "TXXX" || frame.opaque || len(frame.body) == 0 {
+		return frame.id
+	}
+	encoding, body := frame.body[0], frame.body[1:]
+	terminator := []byte{0}
+	if encoding == id3EncodingUTF16 || encoding == id3EncodingUTF16BE {
+		terminator = []byte{0, 0}
+	}
+	for i := 0; i+len(terminator) <= len(body); i += len(terminator) {
+		if bytes.Equal(body[i:i+len(terminator)], terminator) {
+			body = body[:i]
+			break
+		}
+	}
+	description := ""
+	if values := id3TextValues(encoding, body); len(values) > 0 {
+		description = values[0]
+	}
+	return frame.id + ":" + description
+}
+
+// newID3TextFrame returns a UTF-8 text frame with null separated values, named by frame id or TXXX:description.
+func newID3TextFrame(name string, values []string) id3Frame {
+	body := []byte{id3EncodingUTF8}
+	if strings.HasPrefix(name, "TXXX:") {
+		body = append(append(body, name[len("TXXX:"):]...), 0)
+		name = "TXXX"
+	}
+	return id3Frame{id: name, body: append(body, strings.Join(values, "\x00")...)}
+}
+
+// putSyncsafe encodes a 4 byte integer with 7 bits in each byte, see syncsafe.
+func putSyncsafe(b []byte, n uint32) {
+	b[0], b[1], b[2], b[3] = byte(n>>21&0x7F), byte(n>>14&0x7F), byte(n>>7&0x7F), byte(n&0x7F)
+}
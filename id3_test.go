@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testID3v23Frame returns an ID3v2.3 frame with v2.3 status and format flags.
+func testID3v23Frame(id string, status, format byte, body []byte) []byte {
+	header := make([]byte, id3FrameHeaderSize)
+	copy(header, id)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(body)))
+	header[8], header[9] = status, format
+	return append(header, body...)
+}
+
+// testID3v23Tag returns an ID3v2.3 tag of frames and padding.
+func testID3v23Tag(frames [][]byte, padding int) []byte {
+	body := bytes.Join(frames, nil)
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(header[6:10], uint32(len(body)+padding))
+	return append(append(header, body...), make([]byte, padding)...)
+}
+
+func testID3Text(encoding byte, value string) []byte {
+	return append([]byte{encoding}, value...)
+}
+
+func TestUpgradeID3Frames(t *testing.T) {
+	tests := []struct {
+		frames [][]byte
+		want   map[string]string
+	}{
+		{
+			frames: [][]byte{
+				testID3v23Frame("TYER", 0, 0, testID3Text(id3EncodingISO8859, "1999")),
+				testID3v23Frame("TDAT", 0, 0, testID3Text(id3EncodingISO8859, "0302")),
+				testID3v23Frame("TIME", 0, 0, testID3Text(id3EncodingISO8859, "1200")),
+				testID3v23Frame("TORY", 0, 0, testID3Text(id3EncodingISO8859, "1998")),
+				testID3v23Frame("TSIZ", 0, 0, testID3Text(id3EncodingISO8859, "1000")),
+			},
+			want: map[string]string{"TDRC": "1999-02-03", "TDOR": "1998"},
+		},
+		{
+			frames: [][]byte{
+				testID3v23Frame("TYER", 0, 0, testID3Text(id3EncodingISO8859, "1999")),
+				testID3v23Frame("TDAT", 0, 0, testID3Text(id3EncodingISO8859, "2")),
+			},
+			want: map[string]string{"TDRC": "1999"},
+		},
+		{
+			frames: [][]byte{
+				testID3v23Frame("TYER", 0, 0, testID3Text(id3EncodingISO8859, "1999")),
+				testID3v23Frame("TDRC", 0, 0, testID3Text(id3EncodingUTF8, "2001-05")),
+				testID3v23Frame("TIT2", 0, 0, testID3Text(id3EncodingUTF8, "Title")),
+			},
+			want: map[string]string{"TDRC": "2001-05", "TIT2": "Title"},
+		},
+	}
+	for _, test := range tests {
+		tag, err := readID3Tag(bytes.NewReader(testID3v23Tag(test.frames, 0)))
+		if err != nil || tag == nil {
+			t.Fatal(tag, err)
+		}
+		got := make(map[string]string)
+		for _, frame := range upgradeID3Frames(tag.frames) {
+			got[frame.id] = strings.Join(id3TextValues(frame.body[0], frame.body[1:]), "/")
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("got frames %v, want %v", got, test.want)
+		}
+		for id, want := range test.want {
+			if got[id] != want {
+				t.Errorf("got %s %q, want %q", id, got[id], want)
+			}
+		}
+	}
+}
+
+func TestWriteID3Tag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "id3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audio := append(bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 256), []byte("TAG")...)
+	audio = append(audio, make([]byte, id3v1Size-3)...)
+	frames := [][]byte{
+		// v2.3 tag alter preservation and grouping identity flags, with the group id before the text
+		testID3v23Frame("TPE1", 0x80, 0x20, append([]byte{7}, testID3Text(id3EncodingISO8859, "Muse")...)),
+		// v2.3 read only flag
+		testID3v23Frame("TIT2", 0x20, 0, testID3Text(id3EncodingUTF16, "\xff\xfeO\x00l\x00d\x00")),
+		testID3v23Frame("TYER", 0, 0, testID3Text(id3EncodingISO8859, "1999")),
+		testID3v23Frame("TDAT", 0, 0, testID3Text(id3EncodingISO8859, "0302")),
+	}
+	tests := []struct {
+		name      string
+		tag       []byte
+		rewritten bool
+	}{
+		{name: "fits in the padding", tag: testID3v23Tag(frames, 200)},
+		{name: "no padding", tag: testID3v23Tag(frames, 0), rewritten: true},
+		{name: "no tag", rewritten: true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "song.mp3")
+		original := append(append([]byte(nil), test.tag...), audio...)
+		if err := ioutil.WriteFile(path, original, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash := testSum(t, path, id3AudioSum)
+		edits := []tagEdit{{field: "album", values: []string{"Absolution"}}}
+		if err := writeID3Tag(path, edits); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := testSum(t, path, id3AudioSum); got != hash {
+			t.Errorf("%s: hash changed from %v to %v", test.name, hash, got)
+		}
+		written, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten := len(written) != len(original); rewritten != test.rewritten {
+			t.Errorf("%s: file size changed from %d to %d", test.name, len(original), len(written))
+		}
+		tag, err := readID3Tag(bufio.NewReader(bytes.NewReader(written)))
+		if err != nil || tag == nil {
+			t.Errorf("%s: no tag written: %v", test.name, err)
+			continue
+		}
+		if tag.version != 4 || !bytes.Equal(written[tag.size:], audio) {
+			t.Errorf("%s: got ID3v2.%d tag of %d bytes before the audio", test.name, tag.version, tag.size)
+		}
+
+		type frame struct {
+			status, format, group byte
+			value                 string
+		}
+		want := map[string]frame{"TALB": {value: "Absolution"}}
+		if test.tag != nil {
+			want["TPE1"] = frame{status: 0x40, format: id3FrameGroupFlag, group: 7, value: "Muse"}
+			want["TIT2"] = frame{status: 0x10, value: "Old"}
+			want["TDRC"] = frame{value: "1999-02-03"}
+		}
+		got := make(map[string]frame)
+		for _, f := range tag.frames {
+			parsed := frame{status: f.status, format: f.format}
+			body := f.body
+			if f.format&id3FrameGroupFlag != 0 {
+				parsed.group, body = body[0], body[1:]
+			}
+			parsed.value = strings.Join(id3TextValues(body[0], body[1:]), "/")
+			got[f.id] = parsed
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got frames %v, want %v", test.name, got, want)
+		}
+		for id, w := range want {
+			if got[id] != w {
+				t.Errorf("%s: got %s %+v, want %+v", test.name, id, got[id], w)
+			}
+		}
+	}
+}
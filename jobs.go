@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// number of finished jobs kept after they are done
+	maxFinishedJobs = 50
+	// number of log lines kept for each job
+	maxJobLogLines = 1000
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// jobKind is a kind of background job, like a library scan or mobile sync.
+type jobKind struct {
+	// jobs sharing any resource never run at the same time, and start in the order they were queued
+	resources []string
+	// run does the work of the job, it should stop early and return the context error if ctx is canceled
+	run func(ctx context.Context, logger *log.Logger) error
+}
+
+// jobReport is a snapshot of the state of a job.
+type jobReport struct {
+	ID       int       `json:"id"`
+	Kind     string    `json:"kind"`
+	State    string    `json:"state"`
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+	Log      []string  `json:"log,omitempty"`
+}
+
+// job is a queued, running, or finished job. It is an io.Writer keeping the last lines logged by the job.
+type job struct {
+	kind   jobKind
+	report jobReport // guarded by the jobManager lock
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	logLock sync.Mutex
+	log     []string
+}
+
+func (j *job) Write(p []byte) (int, error) {
+	j.logLock.Lock()
+	defer j.logLock.Unlock()
+	j.log = append(j.log, strings.Split(strings.TrimSuffix(string(p), "\n"), "\n")...)
+	if len(j.log) > maxJobLogLines {
+		j.log = j.log[len(j.log)-maxJobLogLines:]
+	}
+	return len(p), nil
+}
+
+func (j *job) logLines() []string {
+	j.logLock.Lock()
+	defer j.logLock.Unlock()
+	return append([]string{}, j.log...)
+}
+
+func (j *job) conflicts(other *job) bool {
+	for _, a := range j.kind.resources {
+		for _, b := range other.kind.resources {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jobManager runs jobs in the background, serializing jobs that use the same resources.
+type jobManager struct {
+	kinds map[string]jobKind
+
+	lock   sync.Mutex
+	nextID int
+	jobs   []*job
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{kinds: make(map[string]jobKind), nextID: 1}
+}
+
+// register adds a kind of job that can be started by name.
+func (m *jobManager) register(name string, kind jobKind) {
+	m.kinds[name] = kind
+}
+
+func (m *jobManager) hasKind(name string) bool {
+	_, ok := m.kinds[name]
+	return ok
+}
+
+// kindNames returns the sorted names of all registered kinds of job.
+func (m *jobManager) kindNames() []string {
+	var result []string
+	for name := range m.kinds {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// start queues a new job of the named kind, which runs as soon as no conflicting job is running or queued before it.
+func (m *jobManager) start(name string) (jobReport, error) {
+	kind, ok := m.kinds[name]
+	if !ok {
+		return jobReport{}, fmt.Errorf("unknown job kind %q, expected one of %v", name, m.kindNames())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j := &job{
+		kind:   kind,
+		report: jobReport{ID: m.nextID, Kind: name, State: jobQueued, Queued: time.Now()},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	m.nextID++
+	m.jobs = append(m.jobs, j)
+	m.schedule()
+	return j.report, nil
+}
+
+// schedule starts each queued job that does not conflict with a running or earlier queued job, lock must be held.
+func (m *jobManager) schedule() {
+	var blocking []*job
+	for _, j := range m.jobs {
+		switch j.report.State {
+		case jobRunning:
+			blocking = append(blocking, j)
+		case jobQueued:
+			blocked := false
+			for _, other := range blocking {
+				if j.conflicts(other) {
+					blocked = true
+					break
+				}
+			}
+			if !blocked {
+				j.report.State = jobRunning
+				j.report.Started = time.Now()
+				go m.run(j)
+			}
+			blocking = append(blocking, j)
+		}
+	}
+}
+
+func (m *jobManager) run(j *job) {
+	prefix := fmt.Sprintf("[job %v %v] ", j.report.ID, j.report.Kind)
+	logger := log.New(io.MultiWriter(os.Stdout, j), prefix, log.LstdFlags|log.Lmicroseconds)
+	logger.Println("started")
+	err := j.kind.run(j.ctx, logger)
+	if err != nil {
+		logger.Println(err)
+	} else {
+		logger.Println("finished")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j.report.Finished = time.Now()
+	switch {
+	case err == nil:
+		j.report.State = jobSucceeded
+	case j.ctx.Err() != nil:
+		j.report.State = jobCanceled
+		j.report.Error = err.Error()
+	default:
+		j.report.State = jobFailed
+		j.report.Error = err.Error()
+	}
+	j.cancel()
+	m.prune()
+	m.schedule()
+}
+
+// prune removes the oldest finished jobs past maxFinishedJobs, lock must be held.
+func (m *jobManager) prune() {
+	finished := 0
+	for _, j := range m.jobs {
+		if !j.report.Finished.IsZero() {
+			finished++
+		}
+	}
+	var kept []*job
+	for _, j := range m.jobs {
+		if finished > maxFinishedJobs && !j.report.Finished.IsZero() {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	m.jobs = kept
+}
+
+// list returns a snapshot of each job without logs, oldest first.
+func (m *jobManager) list() []jobReport {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := []jobReport{}
+	for _, j := range m.jobs {
+		result = append(result, j.report)
+	}
+	return result
+}
+
+func (m *jobManager) find(id int) *job {
+	for _, j := range m.jobs {
+		if j.report.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// get returns a snapshot of a job with its log, or false if there is no such job.
+func (m *jobManager) get(id int) (jobReport, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j := m.find(id)
+	if j == nil {
+		return jobReport{}, false
+	}
+	result := j.report
+	result.Log = j.logLines()
+	return result, true
+}
+
+// cancel cancels a job, or returns false if there is no such job.
+// A queued job is canceled right away, a running job is canceled once it notices its context is done.
+func (m *jobManager) cancel(id int) (jobReport, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j := m.find(id)
+	if j == nil {
+		return jobReport{}, false
+	}
+	j.cancel()
+	if j.report.State == jobQueued {
+		j.report.State = jobCanceled
+		j.report.Finished = time.Now()
+		m.prune()
+		m.schedule()
+	}
+	return j.report, true
+}
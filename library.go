@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto/sha512"
 	"encoding/gob"
 	"fmt"
+	"github.com/shawnsmithdev/tag"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,6 +18,10 @@ const (
 	megabyte = 1024 * 1024
 	// SHA512_256 hash is 256 bits = 32 bytes
 	picHashSize = 32
+	// changes whenever songs of some file type are hashed differently, so that incremental scans read them again.
+	// Since version 1, MP3 audio is hashed without the ID3v2 tag, see id3AudioSum,
+	// and since version 2, opus audio is hashed without ogg page headers, see readOpusMeta.
+	currentHashVersion = 2
 )
 
 // picHash is a 32 byte array that represents the SHA512_256 hash of a picture file.
@@ -38,11 +47,19 @@ type Library interface {
 	songCount() int
 	songs(toDo func(*Song) error) error
 	storeDb(db string) error
+	metadataOverrides() map[songHash]metadataOverride
+	tagDelimiters() []string
+	songHashVersion() int
+	withOverrides(overrides map[songHash]metadataOverride, logger *log.Logger) Library
+	withSong(song *Song) Library
 }
 
 type library struct {
-	SongMap map[songHash]*Song
-	ArtMap  map[picHash]*Art
+	SongMap     map[songHash]*Song
+	ArtMap      map[picHash]*Art
+	Overrides   map[songHash]metadataOverride // see metadataOverride
+	Delimiters  []string                      // split the multi-valued tags of the songs, see Song.copyMetadata
+	HashVersion int                           // see currentHashVersion
 }
 
 func (l library) artCount() int {
@@ -57,6 +74,14 @@ func (l library) findSong(key songHash) *Song {
 	return l.SongMap[key]
 }
 
+func (l library) tagDelimiters() []string {
+	return l.Delimiters
+}
+
+func (l library) songHashVersion() int {
+	return l.HashVersion
+}
+
 func (l library) path() string {
 	panic("implement me")
 }
@@ -79,7 +104,29 @@ func (l *library) putSongAndArt(songAndArt songAndArt, logger *log.Logger) {
 		}
 		song.Art = artFile
 	}
-	l.SongMap[songAndArt.song.Hash] = song
+	if existing, ok := l.SongMap[song.Hash]; ok && existing.Path != song.Path {
+		song = withDuplicatePath(existing, song)
+		logger.Printf("same audio at %q and %q", song.Path, song.DuplicatePaths)
+	}
+	l.SongMap[song.Hash] = song
+}
+
+// withDuplicatePath returns whichever of two songs with the same audio has the least path,
+// with the other paths of both as its duplicate paths, so that the song kept does not depend on scan order.
+func withDuplicatePath(existing, song *Song) *Song {
+	paths := append([]string{existing.Path, song.Path}, existing.DuplicatePaths...)
+	sort.Strings(paths)
+	result := existing
+	if song.Path == paths[0] {
+		result = song
+	}
+	result.DuplicatePaths = nil
+	for i, path := range paths[1:] {
+		if path != paths[i] && path != result.Path {
+			result.DuplicatePaths = append(result.DuplicatePaths, path)
+		}
+	}
+	return result
 }
 
 func (l library) songCount() int {
@@ -95,6 +142,22 @@ func (l library) songs(forEach func(*Song) error) error {
 	return nil
 }
 
+// withSong returns a copy of the library, sharing art and overrides, with a song replaced by one with the same hash.
+func (l library) withSong(song *Song) Library {
+	result := &library{
+		SongMap:     make(map[songHash]*Song, len(l.SongMap)),
+		ArtMap:      l.ArtMap,
+		Overrides:   l.Overrides,
+		Delimiters:  l.Delimiters,
+		HashVersion: l.HashVersion,
+	}
+	for hash, other := range l.SongMap {
+		result.SongMap[hash] = other
+	}
+	result.SongMap[song.Hash] = song
+	return result
+}
+
 func newLibrary() *library {
 	return &library{
 		SongMap: make(map[songHash]*Song),
@@ -128,51 +191,233 @@ func (l library) storeDb(db string) error {
 }
 
 type loadLibraryArgs struct {
-	root     string
-	parallel int
-	logger   *log.Logger
-	db       string
-	rescan   bool
+	root       string
+	parallel   int
+	logger     *log.Logger
+	db         string
+	rescan     bool
+	progress   *progressBoard
+	delimiters []string // split multi-valued tags
 }
 
 func loadLibrary(args loadLibraryArgs) Library {
-	var result Library
 	if "" != args.db && !args.rescan {
 		args.logger.Printf("will load library from db at %q", args.db)
 		start := time.Now()
-		result = loadDb(args.db)
+		result := loadDb(args.db)
 		delta := time.Now().Sub(start)
 		args.logger.Printf("loaded library from db at %q with %v songs and %v pics in %v",
 			args.db, result.songCount(), result.artCount(), delta)
 		return result
 	}
+	result, err := scanLibrary(context.Background(), args, nil)
+	forbidErr(err)
+	if "" != args.db {
+		if _, err := os.Stat(args.db); err == nil {
+			args.logger.Printf("will keep metadata overrides from db at %q", args.db)
+			result = result.withOverrides(movedOverrides(loadDb(args.db), result, args.logger), args.logger)
+		}
+	}
+	storeLibrary(args, result)
+	return result
+}
+
+// scanLibrary reads all songs under the root folder into a new library.
+// If previous is not nil, songs with the same path, size and modified time as in previous are not read again,
+// unless previous was split by other tag delimiters.
+// If ctx is canceled, the scan stops and the context error is returned.
+// If finding or reading any file fails, the scan stops and the error is returned.
+func scanLibrary(ctx context.Context, args loadLibraryArgs, previous Library) (Library, error) {
 	start := time.Now()
-	result = newLibrary()
+	result := newLibrary()
+	result.Delimiters = args.delimiters
+	result.HashVersion = currentHashVersion
 	total := int64(0)
-	for songAndArt := range runSongWalkers(args.root, args.parallel) {
+	scanProgress := args.progress.start("scan")
+	defer scanProgress.finish()
+	if previous != nil && !sameStrings(previous.tagDelimiters(), args.delimiters) {
+		args.logger.Printf("tag delimiters changed from %q to %q, reading all songs again",
+			previous.tagDelimiters(), args.delimiters)
+		previous = nil
+	}
+	known := knownSongs(previous)
+	songs, walkErr := runSongWalkers(ctx, args.root, args.parallel, known, args.delimiters, scanProgress)
+	for songAndArt := range songs {
 		args.logger.Printf("found song, path=%q", songAndArt.song.Path)
 		result.putSongAndArt(songAndArt, args.logger)
 		total += songAndArt.song.Size
 	}
+	if err := <-walkErr; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	delta := time.Now().Sub(start)
 	speed := float64(total) / (delta.Seconds() * megabyte)
 	args.logger.Printf("loaded library with %v songs and %v pics in %v (%.0f MB/s)",
 		result.songCount(), result.artCount(), delta, speed)
-	if "" != args.db {
-		args.logger.Printf("will store library to db at %q", args.db)
-		start = time.Now()
-		if err := result.storeDb(args.db); err == nil {
-			delta := time.Now().Sub(start)
-			args.logger.Printf("stored library to db at %q with %v songs and %v pics in %v",
-				args.db, result.songCount(), result.artCount(), delta)
-		} else {
-			args.logger.Printf("failed to store library to db at %q", args.db)
-			args.logger.Print(err)
+	return result, nil
+}
+
+// sameStrings returns true if a and b have the same strings in the same order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
+	return true
+}
+
+// knownSongs returns the songs and art of a library keyed by song path, for incremental scans.
+// Songs of file types hashed differently since the library was scanned are left out, so that they are read
+// and hashed again, see currentHashVersion.
+func knownSongs(lib Library) map[string]songAndArt {
+	result := make(map[string]songAndArt)
+	if lib == nil {
+		return result
+	}
+	oldMP3Hashes, oldOpusHashes := lib.songHashVersion() < 1, lib.songHashVersion() < 2
+	forbidErr(lib.songs(func(song *Song) error {
+		if (oldMP3Hashes && song.FileType == tag.MP3) || (oldOpusHashes && song.FileType == OPUS) {
+			return nil
+		}
+		copied := *song             // the new library must not share songs with the library being served
+		copied.DuplicatePaths = nil // found again by the scan
+		known := songAndArt{song: &copied}
+		if artHash, err := extractPicHash(song.Art); len(song.Art) > 0 && err == nil {
+			known.art = lib.findArt(artHash)
+		}
+		result[song.Path] = known
+		return nil
+	}))
 	return result
 }
 
+// storeLibrary stores the library to the database file, if any.
+func storeLibrary(args loadLibraryArgs, lib Library) {
+	if "" == args.db {
+		return
+	}
+	args.logger.Printf("will store library to db at %q", args.db)
+	start := time.Now()
+	if err := lib.storeDb(args.db); err == nil {
+		delta := time.Now().Sub(start)
+		args.logger.Printf("stored library to db at %q with %v songs and %v pics in %v",
+			args.db, lib.songCount(), lib.artCount(), delta)
+	} else {
+		args.logger.Printf("failed to store library to db at %q", args.db)
+		args.logger.Print(err)
+	}
+}
+
+// libraryHolder holds the library being served, which is swapped out when a scan job finishes,
+// or when metadata overrides change.
+type libraryHolder struct {
+	lock      sync.RWMutex
+	write     sync.Mutex // held while replacing the library
+	lib       Library
+	aad       Collection
+	classical Collection
+	index     *searchIndex
+	args      collectionArgs
+	logger    *log.Logger
+}
+
+func newLibraryHolder(lib Library, args collectionArgs, logger *log.Logger) *libraryHolder {
+	result := &libraryHolder{args: args, logger: logger}
+	result.set(lib)
+	return result
+}
+
+func (h *libraryHolder) library() Library {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.lib
+}
+
+func (h *libraryHolder) collection() Collection {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.aad
+}
+
+func (h *libraryHolder) classicalCollection() Collection {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.classical
+}
+
+func (h *libraryHolder) searchIndex() *searchIndex {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.index
+}
+
+// set replaces the library being served, and rebuilds its collection and search index.
+// The metadata overrides of the library being replaced are applied to the new one, see movedOverrides.
+func (h *libraryHolder) set(lib Library) {
+	h.write.Lock()
+	defer h.write.Unlock()
+	if current := h.library(); current != nil {
+		lib = lib.withOverrides(movedOverrides(current, lib, h.logger), h.logger)
+	}
+	h.swap(lib)
+}
+
+// override changes the metadata override of a song, and returns the song with its overrides.
+// Fields with a nil value are no longer overridden.
+func (h *libraryHolder) override(hash songHash, fields map[string]*string) (*Song, error) {
+	h.write.Lock()
+	defer h.write.Unlock()
+	current := h.library()
+	song := current.findSong(hash)
+	if song == nil {
+		return nil, fmt.Errorf("couldn't find song for hash: %v", hash)
+	}
+	override := make(metadataOverride)
+	for field, value := range current.metadataOverrides()[hash] {
+		override[field] = value
+	}
+	for field, value := range fields {
+		field = strings.ToLower(field)
+		if value == nil {
+			delete(override, field)
+		} else {
+			override[field] = *value
+		}
+	}
+	if _, err := song.withOverride(override); err != nil {
+		return nil, err
+	}
+	overrides := make(map[songHash]metadataOverride)
+	for other, otherOverride := range current.metadataOverrides() {
+		overrides[other] = otherOverride
+	}
+	if len(override) > 0 {
+		overrides[hash] = override
+	} else {
+		delete(overrides, hash)
+	}
+	lib := current.withOverrides(overrides, h.logger)
+	h.swap(lib)
+	return lib.findSong(hash), nil
+}
+
+// swap rebuilds the collections and search index of a library, and then serves it.
+func (h *libraryHolder) swap(lib Library) {
+	aad := ArtistAblumDateCollection(lib, h.args, h.logger)
+	classical := ClassicalCollection(lib, h.args, h.logger)
+	index := newSearchIndex(lib, h.args.aliases, h.logger)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lib, h.aad, h.classical, h.index = lib, aad, classical, index
+}
+
 func closeFile(f *os.File) {
 	forbidErr(f.Close())
 }
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
+	"fmt"
+	"golang.org/x/text/language"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,6 +28,11 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == syncClientCommand {
+		forbidErr(runSyncClient(os.Args[2:]))
+		return
+	}
+
 	var (
 		root       string
 		parallel   int
@@ -29,8 +41,35 @@ func main() {
 		db         string
 		doRescanDb bool
 
-		mobile       string
-		doSyncMobile bool
+		mobile         string
+		doSyncMobile   bool
+		mobileBudget   string
+		mobilePriority string
+		mobileFs       string
+
+		mobilePlaylists      string
+		mobileAlbumPlaylists bool
+
+		mobileArtSize    int
+		mobileArtQuality int
+		mobileCovers     bool
+		mobileEmbedArt   bool
+
+		doVerifyMobile bool
+		doRepairMobile bool
+
+		doServeMobile bool
+		mobileCache   string
+
+		progressInterval time.Duration
+		configFile       string
+		tagDelimiters    string
+		report           string
+		variousArtists   string
+		collateLocale    string
+		sortArticles     string
+		writeTags        bool
+		formatPreference string
 	)
 	flag.StringVar(&root, "root", "", "root music library folder")
 	flag.IntVar(&parallel, "p", 1, "parallelism of library loading")
@@ -40,11 +79,84 @@ func main() {
 	flag.BoolVar(&doRescanDb, "rescan-database", false, "if true, rescans existing database")
 	flag.StringVar(&mobile, "mobile", "", "optional mobile music library folder")
 	flag.BoolVar(&doSyncMobile, "sync-mobile", false, "run mobile library sync")
+	flag.StringVar(&mobileBudget, "mobile-budget", "", "max size of mobile library, ex. 60G or 500M (decimal), or 56Gi (binary), default is no limit")
+	flag.StringVar(&mobileFs, "mobile-fs", nativeFs,
+		"filesystem of mobile library, fat rewrites paths to be safe on FAT32/exFAT sd cards, or native")
+	flag.StringVar(&mobilePlaylists, "mobile-playlists", "*",
+		"comma separated name patterns of root library playlists to sync to mobile library, ex. favorites,road*")
+	flag.BoolVar(&mobileAlbumPlaylists, "mobile-album-playlists", false, "write a playlist for each mobile album")
+	flag.IntVar(&mobileArtSize, "mobile-art-size", 0, "max width and height of mobile library art in pixels, default is no resizing")
+	flag.IntVar(&mobileArtQuality, "mobile-art-quality", defaultArtQuality, "jpeg quality of resized mobile library art")
+	flag.BoolVar(&mobileCovers, "mobile-covers", false, "write a "+coverFile+" to each mobile album folder")
+	flag.BoolVar(&mobileEmbedArt, "mobile-embed-art", false, "embed the (resized) front cover in each opus file")
+	flag.StringVar(&mobilePriority, "mobile-priority", "path",
+		"songs to prefer when over mobile budget: path, recent, playlist, or a query like artist=muse,date=2001")
+	flag.BoolVar(&doVerifyMobile, "verify-mobile", false, "verify mobile library files against the root library")
+	flag.BoolVar(&doRepairMobile, "repair-mobile", false, "with -verify-mobile, sync bad and missing files again")
+	flag.BoolVar(&doServeMobile, "serve-mobile", false,
+		"serve the mobile library to '"+syncClientCommand+"' clients, using the same mobile options as -sync-mobile")
+	flag.StringVar(&mobileCache, "mobile-cache", filepath.Join(os.TempDir(), "discographic"),
+		"folder to cache opus files served to sync clients")
+
+	flag.DurationVar(&progressInterval, "progress", 10*time.Second,
+		"interval to log progress of scans and syncs, 0 to disable")
+	flag.StringVar(&configFile, "config", "",
+		"optional json config file with mobile profiles, scheduled jobs and artist aliases")
+	flag.StringVar(&tagDelimiters, "tag-delimiters", defaultTagDelimiters,
+		"| separated delimiters that split artist, album artist, composer and genre tags into multiple values")
+	flag.StringVar(&variousArtists, "various-artists", defaultVariousArtists,
+		"artist to list compilations under, each song is also listed under its own artists as appearing on the compilation")
+	flag.StringVar(&collateLocale, "collate-locale", "en",
+		"locale of the order of artist and album names, ex. en, de, sv, fr-CA")
+	flag.StringVar(&sortArticles, "sort-articles", "",
+		"comma separated leading articles ignored when sorting artists and albums, ex. the,a,les,l'")
+	flag.BoolVar(&writeTags, "write-tags", false,
+		"allow tag edits from the REST api to be written to root library FLAC and MP3 files")
+	flag.StringVar(&formatPreference, "format-preference", "",
+		"comma separated file types, most preferred first, to list albums in several formats once, "+
+			"with the others as alternates, ex. flac,alac,m4a,mp3, default is to list each version")
+	flag.StringVar(&report, "report", "",
+		"print a report about the root library and exit: albums (songs that may be merged into the wrong album), "+
+			"artists (names that are probably the same artist), "+
+			"health (missing tracks or art, and tags that differ across the songs of an album), "+
+			"or duplicates (files with identical audio, and likely copies of a recording in other formats)")
 
 	flag.Parse()
 	if len(root) == 0 {
 		panic("Must provide --root argument for music library root folder")
 	}
+	budget, err := parseByteSize(mobileBudget)
+	if err != nil {
+		panic(err)
+	}
+	if "" != report {
+		if err := checkReport(report); err != nil {
+			panic(err)
+		}
+	}
+	locale, err := language.Parse(collateLocale)
+	if err != nil {
+		panic(fmt.Errorf("invalid collate locale %q: %v", collateLocale, err))
+	}
+	cfg, err := readConfig(configFile)
+	if err != nil {
+		panic(err)
+	}
+	aliases, err := newArtistAliases(cfg.ArtistAliases)
+	if err != nil {
+		panic(err)
+	}
+	formats, err := parseFormatPreference(formatPreference)
+	if err != nil {
+		panic(err)
+	}
+	colArgs := collectionArgs{
+		variousArtists: variousArtists,
+		locale:         locale,
+		articles:       parseSortArticles(sortArticles),
+		aliases:        aliases,
+		formats:        formats,
+	}
 	if parallel < minParallel {
 		parallel = minParallel
 	} else if parallel > maxParallel {
@@ -52,26 +164,111 @@ func main() {
 	}
 
 	loadLog := log.New(os.Stdout, "[load] ", log.LstdFlags|log.Lmicroseconds)
-	lib := loadLibrary(loadLibraryArgs{
-		root:     root,
-		parallel: parallel,
-		logger:   loadLog,
-		db:       db,
-		rescan:   doRescanDb,
-	})
-	aad := ArtistAblumDateCollection(lib, loadLog)
+	progressLog := log.New(os.Stdout, "[progress] ", log.LstdFlags|log.Lmicroseconds)
+	mobileLog := log.New(os.Stdout, "[mobile] ", log.LstdFlags|log.Lmicroseconds)
+	progress := newProgressBoard(progressInterval, progressLog)
+	libArgs := loadLibraryArgs{
+		root:       root,
+		parallel:   parallel,
+		logger:     loadLog,
+		db:         db,
+		rescan:     doRescanDb,
+		progress:   progress,
+		delimiters: parseTagDelimiters(tagDelimiters),
+	}
+	lib := loadLibrary(libArgs)
+	if "" != report {
+		forbidErr(writeReport(os.Stdout, report, lib, colArgs))
+		return
+	}
+
+	mobileArgs := mobileSyncArgs{
+		root:     ensurePathSep(root),
+		lib:      lib,
+		budget:   budget,
+		priority: mobilePriority,
+		fs:       mobileFs,
+
+		playlists:      mobilePlaylists,
+		albumPlaylists: mobileAlbumPlaylists,
+
+		artSize:    mobileArtSize,
+		artQuality: mobileArtQuality,
+		covers:     mobileCovers,
+		embedArt:   mobileEmbedArt,
+
+		progress: progress,
+		logger:   mobileLog,
+	}
 
 	loadLog.Println("================================")
 	if "" != mobile {
 		loadLog.Println("mobile library:", mobile)
 		if doSyncMobile {
 			loadLog.Print(mobileSyncWarning)
-			forbidErr(syncMobile(ensurePathSep(root), lib, ensurePathSep(mobile)))
+			mobileArgs.mobile = ensurePathSep(mobile)
+			forbidErr(syncMobile(context.Background(), mobileArgs))
 			return
 		}
+		if doVerifyMobile {
+			if doRepairMobile {
+				loadLog.Print(mobileSyncWarning)
+			}
+			mobileArgs.mobile = ensurePathSep(mobile)
+			if err := verifyMobile(context.Background(), mobileArgs, doRepairMobile); err != nil {
+				loadLog.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	libs := newLibraryHolder(lib, colArgs, loadLog)
+	var mobileSrv *mobileServer
+	if doServeMobile {
+		loadLog.Println("serving mobile library to sync clients, cache:", mobileCache)
+		mobileSrv = newMobileServer(mobileArgs, libs, mobileCache)
+	}
+	if "" != mobile {
+		mobileArgs.mobile = ensurePathSep(mobile)
+	}
+	defaultProfile := mobileProfile{
+		Mobile:         mobile,
+		Budget:         mobileBudget,
+		Priority:       mobilePriority,
+		FS:             mobileFs,
+		Playlists:      mobilePlaylists,
+		AlbumPlaylists: mobileAlbumPlaylists,
+		ArtSize:        mobileArtSize,
+		ArtQuality:     mobileArtQuality,
+		Covers:         mobileCovers,
+		EmbedArt:       mobileEmbedArt,
+	}
+	profiles, err := cfg.mobileProfiles(defaultProfile)
+	if err != nil {
+		panic(err)
+	}
+	profileArgs := make(map[string]mobileSyncArgs, len(profiles))
+	for name, profile := range profiles {
+		if profileArgs[name], err = profile.syncArgs(mobileArgs); err != nil {
+			panic(fmt.Errorf("invalid mobile profile %q: %v", name, err))
+		}
+	}
+	jobs := newJobManager()
+	registerJobs(jobs, libs, libArgs, mobileArgs, profileArgs)
+	loadLog.Println("jobs:", strings.Join(jobs.kindNames(), ", "))
+	scheduleLog := log.New(os.Stdout, "[schedule] ", log.LstdFlags|log.Lmicroseconds)
+	schedules, err := newScheduler(jobs, cfg.Schedules, scheduleLog)
+	if err != nil {
+		panic(err)
 	}
+	schedules.start()
 	loadLog.Println("================================")
-	server := buildServer(aad, lib, gui)
+	var tags *tagWriter
+	if writeTags {
+		loadLog.Println("tag edits will be written to root library files")
+		tags = &tagWriter{libs: libs, delimiters: libArgs.delimiters}
+	}
+	server := buildServer(libs, gui, mobileSrv, tags, progress, jobs, schedules)
 	server.Addr = address
 
 	logAddress := address
@@ -82,6 +279,69 @@ func main() {
 	forbidErr(server.ListenAndServe())
 }
 
+// registerJobs adds the kinds of background jobs that can be started from the REST api or a schedule.
+// Mobile jobs are only available if a mobile library folder is given, and for each mobile profile,
+// ex. sync-mobile:phone. Storing the database is only available if a database file is given.
+func registerJobs(jobs *jobManager, libs *libraryHolder, libArgs loadLibraryArgs, mobileArgs mobileSyncArgs,
+	profiles map[string]mobileSyncArgs) {
+	scan := func(incremental bool) func(context.Context, *log.Logger) error {
+		return func(ctx context.Context, logger *log.Logger) error {
+			args := libArgs
+			args.logger = logger
+			var previous Library
+			if incremental {
+				previous = libs.library()
+			}
+			lib, err := scanLibrary(ctx, args, previous)
+			if err != nil {
+				return err
+			}
+			libs.set(lib)
+			storeLibrary(args, libs.library())
+			return nil
+		}
+	}
+	jobs.register("scan", jobKind{resources: []string{"library"}, run: scan(true)})
+	jobs.register("rescan", jobKind{resources: []string{"library"}, run: scan(false)})
+	if "" != libArgs.db {
+		jobs.register("store-database", jobKind{resources: []string{"library"},
+			run: func(ctx context.Context, logger *log.Logger) error {
+				args := libArgs
+				args.logger = logger
+				storeLibrary(args, libs.library())
+				return nil
+			}})
+	}
+	if "" != mobileArgs.mobile {
+		registerMobileJobs(jobs, libs, mobileArgs, "")
+	}
+	for name, args := range profiles {
+		registerMobileJobs(jobs, libs, args, ":"+name)
+	}
+}
+
+func registerMobileJobs(jobs *jobManager, libs *libraryHolder, mobileArgs mobileSyncArgs, suffix string) {
+	mobile := func(run func(context.Context, mobileSyncArgs) error) func(context.Context, *log.Logger) error {
+		return func(ctx context.Context, logger *log.Logger) error {
+			args := mobileArgs
+			args.lib = libs.library()
+			args.logger = logger
+			return run(ctx, args)
+		}
+	}
+	// mobile jobs use the library so that they run after any scan queued before them
+	mobileResources := []string{"library", "mobile:" + mobileArgs.mobile}
+	jobs.register("sync-mobile"+suffix, jobKind{resources: mobileResources, run: mobile(syncMobile)})
+	jobs.register("verify-mobile"+suffix, jobKind{resources: mobileResources, run: mobile(
+		func(ctx context.Context, args mobileSyncArgs) error {
+			return verifyMobile(ctx, args, false)
+		})})
+	jobs.register("repair-mobile"+suffix, jobKind{resources: mobileResources, run: mobile(
+		func(ctx context.Context, args mobileSyncArgs) error {
+			return verifyMobile(ctx, args, true)
+		})})
+}
+
 // Don't use this on user error, use 4XX HTTP codes instead.
 // Don't use this for ordinary or exceptional errors, use errors and 5xx HTTP codes instead.
 // This is fine for things that should be absolutely impossible (server bugs).
@@ -97,3 +357,30 @@ func bytesToString(data []byte) string {
 	hash64 := base64.URLEncoding.EncodeToString(data)
 	return strings.Replace(hash64, "=", "", -1)
 }
+
+// parses a size in bytes with an optional decimal K, M, G or T suffix, ex. "64G" is 64,000,000,000 bytes,
+// or a binary Ki, Mi, Gi or Ti suffix, ex. "64Gi" is 64 * 2^30 bytes
+// empty string is zero
+func parseByteSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(size), "B"))
+	if len(size) == 0 {
+		return 0, nil
+	}
+	base := 1000.0
+	if strings.HasSuffix(size, "I") {
+		base = 1024
+		size = size[:len(size)-1]
+	}
+	multiplier := 1.0
+	if unit := strings.LastIndexAny(size, "KMGT"); unit >= 0 && unit == len(size)-1 {
+		multiplier = math.Pow(base, float64(strings.IndexByte("KMGT", size[unit])+1))
+		size = size[:unit]
+	} else if base == 1024 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(value * multiplier), nil
+}
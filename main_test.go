@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "1000", want: 1000},
+		{size: "500M", want: 500000000},
+		{size: "64G", want: 64000000000},
+		{size: "64GB", want: 64000000000},
+		{size: "1.5k", want: 1500},
+		{size: "2T", want: 2000000000000},
+		{size: "1Ki", want: 1024},
+		{size: "64Gi", want: 64 << 30},
+		{size: "64GiB", want: 64 << 30},
+		{size: "Gi", wantErr: true},
+		{size: "64i", wantErr: true},
+		{size: "G", wantErr: true},
+		{size: "-1G", wantErr: true},
+		{size: "64X", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.size)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, want error %v", test.size, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
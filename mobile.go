@@ -2,91 +2,375 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"github.com/shawnsmithdev/tag"
 	"golang.org/x/sync/errgroup"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-const mobileSyncWarning = `Only audio, jpg/jpeg, and png files are synced to the mobile library.
-ALL EXISTING FILES in the mobile library that are not audio, jpg, or png WILL BE ERASED.
+const (
+	// bitrate of opus files encoded from FLAC, in kbps
+	opusBitrate = 256
+	// ogg container overhead of opus files, used to estimate encoded size
+	opusOverheadPercent = 2
+	// rough average bitrate of FLAC files in kbps, used to estimate encoded size if duration is unknown
+	flacTypicalBitrate = 900
+)
+
+const mobileSyncWarning = `Only audio, jpg/jpeg, png, and selected playlist files are synced to the mobile library.
+ALL EXISTING FILES in the mobile library that are not audio, jpg, png, or synced playlists WILL BE ERASED.
 ALL EXISTING FILES in the mobile library that do not have a related file in the root library WILL BE ERASED.
+ALL EXISTING FILES in the mobile library that do not fit the mobile budget WILL BE ERASED.
 ALL EXISTING FILES in the mobile library that are older than the related file in the root library WILL BE OVERWRITTEN.
 `
 
-// syncMobile overwrites the contents of the filesystem at mobile with the audio and picture files
-// of the filesystem at root, except where a file is a FLAC audio file, where instead
-// an opus encoded copy may be made.
-// If mobile does not exist yet, it will be created.
-// Files are not overwritten if already present with the same or newer modified timestamp as root.
-func syncMobile(root string, lib Library, mobile string) error {
+// mobileSyncArgs are the options for a mobile library sync.
+type mobileSyncArgs struct {
+	root     string
+	lib      Library
+	mobile   string
+	budget   int64  // max total size of the mobile library in bytes, or 0 for no limit
+	priority string // order songs are selected in when over budget, see sortMobileFiles
+	fs       string // filesystem of the mobile library, see sanitizeMobilePath
+
+	playlists      string // root library playlists to sync, see matchesPlaylist
+	albumPlaylists bool   // if true, write a playlist for each synced album
+
+	artSize    int  // max width and height of synced art in pixels, or 0 to copy art as is
+	artQuality int  // jpeg quality of resized art
+	covers     bool // if true, write a cover.jpg to each synced album folder
+	embedArt   bool // if true, embed the front cover in each opus file instead of any art carried over from FLAC
+
+	progress *progressBoard
+	logger   *log.Logger
+}
+
+// mobileFile is a root library file that may be synced to the mobile library.
+type mobileFile struct {
+	rootPath string // path relative to root
+	fileType string // audio file type, JPG/PNG for art, or M3U8 for playlists
+	song     *Song  // nil if not a song
+	size     int64  // estimated size in the mobile library
+	target   string // path relative to mobile, see assignMobilePaths
+	modTime  time.Time
+	version  string // changes whenever the synced file would change, see versionOf
+
+	entries  []string               // absolute root library paths of playlist entries
+	generate func() ([]byte, error) // generates file data, for files that are not copied from root
+}
+
+// defaultTarget returns the path relative to the mobile library before any filesystem specific rewriting,
+// which differs from the root path for FLAC files encoded to opus, and for playlists which are always m3u8.
+func (mf *mobileFile) defaultTarget() string {
+	rootExt := filepath.Ext(mf.rootPath)
+	switch mf.fileType {
+	case string(tag.FLAC):
+		return mf.rootPath[:len(mf.rootPath)-len(rootExt)] + ".opus"
+	case "M3U8":
+		return mf.rootPath[:len(mf.rootPath)-len(rootExt)] + ".m3u8"
+	}
+	return mf.rootPath
+}
+
+// mobilePlan is the set of files wanted in a mobile library.
+type mobilePlan struct {
+	args     mobileSyncArgs
+	wanted   map[string]*mobileFile // keyed by mobilePathKey
+	previous map[string]syncedPath  // each mobile path key from the last sync, nil if unknown
+	pictures *pictureCache
+}
+
+// keys returns the keys of wanted files in sorted order, which keeps albums together.
+func (p *mobilePlan) keys() []string {
+	var keys []string
+	for key := range p.wanted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// planMobile chooses the audio and picture files of the filesystem at root that are wanted in a mobile library,
+// and their paths in the mobile library.
+// If a budget is given, only the songs that fit are selected, in priority order.
+// Selected playlists are rewritten to point at the synced files.
+func planMobile(args mobileSyncArgs) (*mobilePlan, error) {
+	root, fs := args.root, args.fs
+	if err := checkMobileFs(fs); err != nil {
+		return nil, err
+	}
+
 	// pre-fill audio files we already know we want
-	rootPaths := make(map[string]string)
-	forbidErr(lib.songs(func(song *Song) error {
+	var songFiles []*mobileFile
+	songPaths := make(map[string]struct{})
+	forbidErr(args.lib.songs(func(song *Song) error {
 		rootPath := song.Path[len(root):]
-		rootPaths[rootPath] = string(song.FileType)
+		songPaths[rootPath] = struct{}{}
+		songFiles = append(songFiles, &mobileFile{
+			rootPath: rootPath,
+			fileType: string(song.FileType),
+			song:     song,
+			size:     estimateMobileSize(song),
+			modTime:  song.ModTime,
+		})
 		return nil
 	}))
-	// add any art files
+	// add any art files, and find playlists
+	var artFiles, playlistFiles []*mobileFile
+	playlisted := make(map[string]struct{})
 	allRoot := make(chan *walkResult)
-	go runWalker(root, allRoot)
+	walkErr := make(chan error, 1)
+	go func() {
+		walkErr <- runWalker(root, allRoot)
+	}()
 	for wr := range allRoot {
-		if _, ok := rootPaths[wr.path]; ok {
+		rootPath := wr.path[len(root):]
+		if _, ok := songPaths[rootPath]; ok {
 			continue
 		}
-		rootPath := wr.path[len(root):]
 		ext := strings.ToLower(filepath.Ext(wr.path))
 		switch ext {
 		case ".jpeg":
 			fallthrough
 		case ".jpg":
-			rootPaths[rootPath] = "JPG"
+			artFiles = append(artFiles, &mobileFile{rootPath: rootPath, fileType: "JPG", size: wr.size, modTime: wr.modTime})
 		case ".png":
-			rootPaths[rootPath] = "PNG"
+			artFiles = append(artFiles, &mobileFile{rootPath: rootPath, fileType: "PNG", size: wr.size, modTime: wr.modTime})
+		case ".m3u", ".m3u8":
+			entries, err := readPlaylist(wr.path)
+			if err != nil {
+				args.logger.Println("failed to read playlist", err)
+			}
+			for _, entry := range entries {
+				playlisted[entry] = struct{}{}
+			}
+			if matchesPlaylist(args.playlists, rootPath) {
+				playlistFiles = append(playlistFiles, &mobileFile{
+					rootPath: rootPath,
+					fileType: "M3U8",
+					entries:  entries,
+				})
+			}
 		} // ignore anything else
 	}
+	if err := <-walkErr; err != nil {
+		return nil, err
+	}
+
+	// choose what fits
+	if err := sortMobileFiles(songFiles, args.priority, playlisted); err != nil {
+		return nil, err
+	}
+	selected, leftOut := budgetMobileFiles(songFiles, artFiles, args.budget, args.logger)
+	logLeftOut(leftOut, args.budget, args.logger)
+	albums := groupMobileAlbums(selected)
+	if args.albumPlaylists {
+		playlistFiles = append(playlistFiles, albumPlaylists(albums)...)
+	}
+	selected = append(selected, playlistFiles...)
+	covers := folderCovers(artFiles)
+	if args.covers {
+		selected = append(selectedWithoutCovers(selected, albums), albumCovers(args, albums, covers)...)
+	}
+	if args.artSize > 0 {
+		for _, mf := range selected {
+			if mf.song == nil && mf.generate == nil && (mf.fileType == "JPG" || mf.fileType == "PNG") {
+				mf.generate = resizedArtFile(root+mf.rootPath, args.artSize, args.artQuality)
+			}
+		}
+	}
+	var previous map[string]syncedPath
+	if len(args.mobile) > 0 {
+		previous = readMobilePaths(fs, args.mobile, args.logger)
+	}
+	wanted := assignMobilePaths(fs, selected, previous, args.logger)
+	synced := make(map[string]*mobileFile)
+	for _, mf := range wanted {
+		synced[root+mf.rootPath] = mf
+	}
+	for _, mf := range playlistFiles {
+		data := m3u8(mf, synced)
+		mf.generate = func() ([]byte, error) {
+			return data, nil
+		}
+		mf.version = versionOf(data)
+	}
+	pictures := newPictureCache(args, covers)
+	for _, mf := range wanted {
+		if len(mf.version) > 0 {
+			continue
+		}
+		if mf.song != nil {
+			mf.version = versionOf(mf.song.Hash, mf.size, mf.modTime, mf.fileType, pictures.version(mf))
+		} else {
+			mf.version = versionOf(mf.rootPath, mf.size, mf.modTime, args.artSize, args.artQuality)
+		}
+	}
+	return &mobilePlan{
+		args:     args,
+		wanted:   wanted,
+		previous: previous,
+		pictures: pictures,
+	}, nil
+}
+
+// changed returns true if the existing mobile file at the target of mf was synced from another root file,
+// or from another version of it, according to the mobile paths recorded by the last sync.
+// Paths recorded without a version are compared by root path only.
+func (p *mobilePlan) changed(mf *mobileFile) bool {
+	if p.previous == nil {
+		return false
+	}
+	synced, ok := p.previous[mobilePathKey(p.args.fs, mf.target)]
+	if !ok || synced.RootPath != mf.rootPath {
+		return true
+	}
+	return len(synced.Version) > 0 && synced.Version != mf.version
+}
+
+// versionOf returns a hexadecimal hash of the given values.
+func versionOf(values ...interface{}) string {
+	hash := sha1.Sum([]byte(fmt.Sprint(values...)))
+	return hex.EncodeToString(hash[:])
+}
+
+// syncMobile overwrites the contents of the filesystem at mobile with the audio and picture files
+// of the filesystem at root, except where a file is a FLAC audio file, where instead
+// an opus encoded copy may be made.
+// If mobile does not exist yet, it will be created.
+// Files are not overwritten if already present with the same or newer modified timestamp as root.
+// See planMobile for which files are synced.
+// If ctx is canceled, no more files are copied or encoded, and the context error is returned.
+func syncMobile(ctx context.Context, args mobileSyncArgs) error {
+	plan, err := planMobile(args)
+	if err != nil {
+		return err
+	}
+	root, mobile, fs, wanted, logger := args.root, args.mobile, args.fs, plan.wanted, args.logger
+	syncProgress := args.progress.start("mobile sync")
+	defer syncProgress.finish()
+	syncProgress.setPhase("deleting")
 
 	// get existing mobile files
-	mobilePaths := runPathWalkers(mobile)
+	if err := os.MkdirAll(mobile, os.ModePerm); err != nil {
+		return err
+	}
+	allMobile, err := runPathWalkers(mobile)
+	if err != nil {
+		return err
+	}
+	mobilePaths := make(map[string]struct{})
+	for path := range allMobile {
+		mobilePaths[path[len(mobile):]] = struct{}{}
+	}
+	delete(mobilePaths, mobilePathsFile)
 
 	// delete unknown
 	// TODO: mod time check
 	// TODO: Consolidate with empty folders delete into one pass
-	log.Println("deleting unknown files")
+	logger.Println("deleting unknown files")
 	var deleted []string
-	for path := range mobilePaths {
-		mobilePath := path[len(mobile):]
-		if _, ok := rootPaths[mobilePath]; ok {
+	for mobilePath := range mobilePaths {
+		if _, ok := wanted[mobilePathKey(fs, mobilePath)]; ok {
 			continue
 		}
-		mobileExt := filepath.Ext(mobilePath)
-		if mobileExt == ".opus" {
-			mobileBase := mobilePath[:len(mobilePath)-len(mobileExt)]
-			flacPath := mobileBase + ".flac"
-			if _, ok := rootPaths[flacPath]; ok {
-				continue
-			}
-		}
+		path := mobile + mobilePath
 		if err := os.Remove(path); err == nil {
-			log.Printf("deleted: %q\n", path)
+			logger.Printf("deleted: %q\n", path)
 			deleted = append(deleted, mobilePath)
 		} else {
-			log.Println(err)
+			logger.Println(err)
 		}
 	}
+	existing := make(map[string]struct{})
 	for _, d := range deleted {
 		delete(mobilePaths, d)
 	}
+	for mobilePath := range mobilePaths {
+		key := mobilePathKey(fs, mobilePath)
+		if mf, ok := wanted[key]; ok && plan.changed(mf) {
+			logger.Printf("replacing %q, now synced from %q version %s\n", mobile+mobilePath, mf.rootPath, mf.version)
+			continue
+		}
+		existing[key] = struct{}{}
+	}
 
 	// delete empty folders
-	log.Println("deleting empty folders")
-	err := filepath.Walk(mobile, func(path string, info os.FileInfo, err error) error {
+	if err := removeEmptyFolders(mobile, logger); err != nil {
+		return err
+	}
+
+	// copy lossy and art
+	for key, mf := range wanted {
+		if _, ok := existing[key]; !ok || mf.fileType == "M3U8" {
+			syncProgress.addTotal(1, mf.rootSize())
+		}
+	}
+	syncProgress.setPhase("copying and encoding")
+	toEncode := make(chan encodeTask, 64)
+	encodeErr := make(chan error)
+	go func() {
+		encodeErr <- encode(ctx, toEncode, syncProgress, logger)
+		logger.Println("encoding complete")
+		close(encodeErr)
+	}()
+	for _, key := range plan.keys() {
+		mf := wanted[key]
+		if _, ok := existing[key]; ok && mf.fileType != "M3U8" {
+			logger.Println("Already exists:", mobile+mf.target)
+			continue
+		}
+		err := ctx.Err()
+		switch {
+		case err != nil:
+		case mf.generate != nil:
+			var data []byte
+			if data, err = mf.generate(); err == nil {
+				err = writeFileIfChanged(data, mobile+mf.target, logger)
+			}
+		case mf.fileType == string(tag.FLAC):
+			toEncode <- encodeTask{
+				inPath:  root + mf.rootPath,
+				outPath: mobile + mf.target,
+				picture: plan.pictures.get(mf),
+				size:    mf.rootSize(),
+			}
+			continue
+		default:
+			err = copyFile(root+mf.rootPath, mobile+mf.target, logger)
+		}
+		if err != nil {
+			close(toEncode)
+			<-encodeErr
+			return err
+		}
+		syncProgress.addDone(1, mf.rootSize())
+	}
+	close(toEncode)
+	err = <-encodeErr
+	if err != nil {
+		logger.Println(err)
+		return err
+	}
+	return writeMobilePaths(mobile, wanted)
+}
+
+func removeEmptyFolders(mobile string, logger *log.Logger) error {
+	logger.Println("deleting empty folders")
+	return filepath.Walk(mobile, func(path string, info os.FileInfo, err error) error {
 		if !info.IsDir() || mobile == path {
 			return nil
 		}
@@ -96,75 +380,206 @@ func syncMobile(root string, lib Library, mobile string) error {
 			}
 			err := os.Remove(path)
 			if err == nil {
-				log.Println("removed empty directory", path)
+				logger.Println("removed empty directory", path)
 			}
 			return err
 		} else {
 			return err
 		}
 	})
+}
 
-	if err != nil {
-		return err
+// rootSize returns the size of the root library file, or zero for generated files.
+func (mf *mobileFile) rootSize() int64 {
+	if mf.song != nil {
+		return mf.song.Size
 	}
+	if mf.generate != nil {
+		return 0
+	}
+	return mf.size
+}
 
-	// copy lossy and art
-	toEncode := make(chan encodeTask, 64)
-	encodeErr := make(chan error)
-	go func() {
-		encodeErr <- encode(toEncode)
-		log.Println("encoding complete")
-		close(encodeErr)
-	}()
-	for path, fileType := range rootPaths {
-		if fileType == string(tag.FLAC) {
-			rootExt := filepath.Ext(path)
-			rootBase := path[:len(path)-len(rootExt)]
-			mobilePath := rootBase + ".opus"
-			if _, ok := mobilePaths[mobilePath]; ok {
-				log.Println("Already exists:", mobile+mobilePath)
-			} else {
-				toEncode <- encodeTask{
-					inPath:  root + path,
-					outPath: mobile + mobilePath,
-				}
+// mobileAlbum is an album of synced songs.
+type mobileAlbum struct {
+	dir   string  // deepest folder containing all songs of the album, relative to root
+	songs []*Song // sorted by disc and track
+}
+
+// groupMobileAlbums groups the songs of the given files into albums, skipping songs without an album name.
+func groupMobileAlbums(files []*mobileFile) []mobileAlbum {
+	dirs := make(map[string]string)
+	albums := make(map[string][]*Song)
+	var keys []string
+	for _, mf := range files {
+		if mf.song == nil || len(mf.song.Album) == 0 {
+			continue
+		}
+		key := songAlbumKey(mf.song)
+		if _, ok := albums[key]; !ok {
+			keys = append(keys, key)
+		}
+		albums[key] = append(albums[key], mf.song)
+		if dir, ok := dirs[key]; ok {
+			dirs[key] = commonDir(dir, filepath.Dir(mf.rootPath))
+		} else {
+			dirs[key] = filepath.Dir(mf.rootPath)
+		}
+	}
+	sort.Strings(keys)
+
+	var result []mobileAlbum
+	for _, key := range keys {
+		songs := albums[key]
+		sort.Slice(songs, compareSongTrack(songs))
+		result = append(result, mobileAlbum{dir: dirs[key], songs: songs})
+	}
+	return result
+}
+
+// commonDir returns the deepest folder containing both relative folders.
+func commonDir(a, b string) string {
+	for a != b {
+		if len(a) > len(b) {
+			a = filepath.Dir(a)
+		} else {
+			b = filepath.Dir(b)
+		}
+	}
+	return a
+}
+
+// estimateMobileSize returns the expected size of a song once synced to the mobile library.
+func estimateMobileSize(song *Song) int64 {
+	if song.FileType != tag.FLAC {
+		return song.Size
+	}
+	if duration, err := flacDuration(song.Path); err == nil {
+		size := int64(duration.Seconds() * opusBitrate * 1000 / 8)
+		return size + size*opusOverheadPercent/100
+	}
+	return song.Size * opusBitrate / flacTypicalBitrate
+}
+
+// sortMobileFiles sorts songs by the order they should be synced when over budget, most wanted first.
+// Priority is one of:
+// "path" (or empty) to keep albums together,
+// "recent" to prefer the most recently modified songs,
+// "playlist" to prefer songs in playlists of the root library,
+// or a query of field=value terms (see parseSongQuery) to prefer matching songs.
+func sortMobileFiles(songFiles []*mobileFile, priority string, playlisted map[string]struct{}) error {
+	var rank func(song *Song) int
+	switch {
+	case priority == "" || priority == "path" || priority == "recent":
+		rank = func(*Song) int { return 0 }
+	case priority == "playlist":
+		rank = func(song *Song) int {
+			if _, ok := playlisted[song.Path]; ok {
+				return 0
+			}
+			return 1
+		}
+	case strings.Contains(priority, "="):
+		query, err := parseSongQuery(priority)
+		if err != nil {
+			return err
+		}
+		rank = func(song *Song) int {
+			if query.matches(song) {
+				return 0
 			}
+			return 1
+		}
+	default:
+		return fmt.Errorf("unknown mobile priority %q", priority)
+	}
+	sort.Slice(songFiles, func(i, j int) bool {
+		songI := songFiles[i].song
+		songJ := songFiles[j].song
+		if rankI, rankJ := rank(songI), rank(songJ); rankI != rankJ {
+			return rankI < rankJ
+		}
+		if priority == "recent" && !songI.ModTime.Equal(songJ.ModTime) {
+			return songI.ModTime.After(songJ.ModTime)
+		}
+		return songI.Path < songJ.Path
+	})
+	return nil
+}
+
+// budgetMobileFiles selects sorted songs until the budget is spent, skipping songs that do not fit.
+// Art is only selected if a selected song is in the same folder or a parent folder.
+// A budget of zero or less selects everything.
+func budgetMobileFiles(songFiles, artFiles []*mobileFile, budget int64, logger *log.Logger) (selected, leftOut []*mobileFile) {
+	if budget <= 0 {
+		selected = append(selected, songFiles...)
+		return append(selected, artFiles...), nil
+	}
+	spent := int64(0)
+	songDirs := make(map[string]struct{})
+	for _, mf := range songFiles {
+		if spent+mf.size > budget {
+			leftOut = append(leftOut, mf)
 			continue
 		}
-		if _, ok := mobilePaths[path]; ok {
-			log.Println("Already exists:", mobile+path)
+		spent += mf.size
+		selected = append(selected, mf)
+		songDirs[filepath.Dir(mf.rootPath)] = struct{}{}
+	}
+	for _, mf := range artFiles {
+		if !hasParentDir(mf.rootPath, songDirs) || spent+mf.size > budget {
+			leftOut = append(leftOut, mf)
 			continue
 		}
-		if err := copyFile(root+path, mobile+path); err != nil {
-			close(toEncode)
-			return err
+		spent += mf.size
+		selected = append(selected, mf)
+	}
+	logger.Printf("selected %v files for mobile library, estimated size %v MB of %v MB budget",
+		len(selected), spent/megabyte, budget/megabyte)
+	return selected, leftOut
+}
+
+// hasParentDir returns true if any folder containing the relative path is in dirs.
+func hasParentDir(path string, dirs map[string]struct{}) bool {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, ok := dirs[dir]; ok {
+			return true
+		}
+		if dir == "." || dir == string(os.PathSeparator) {
+			return false
 		}
 	}
-	close(toEncode)
-	err = <-encodeErr
-	if err != nil {
-		log.Println(err)
+}
+
+func logLeftOut(leftOut []*mobileFile, budget int64, logger *log.Logger) {
+	if len(leftOut) == 0 {
+		return
 	}
-	return err
+	total := int64(0)
+	for _, mf := range leftOut {
+		logger.Printf("left out (size %v): %q", mf.size, mf.rootPath)
+		total += mf.size
+	}
+	logger.Printf("left out %v files (estimated %v MB) that do not fit the %v MB budget",
+		len(leftOut), total/megabyte, budget/megabyte)
 }
 
 func ensureFolders(outFilePath string) error {
 	return os.MkdirAll(filepath.Dir(outFilePath), os.ModePerm)
 }
 
-func writeFile(data []byte, outFilePath string) error {
+func writeFile(data []byte, outFilePath string, logger *log.Logger) (result error) {
 	start := time.Now()
 	if err := ensureFolders(outFilePath); err != nil {
 		return err
 	}
 	if outFile, err := os.Create(outFilePath); err == nil {
 		defer func() {
-			forbidErr(outFile.Sync())
-			forbidErr(outFile.Close())
+			result = closeWrittenFile(outFile, result)
 		}()
 		outCount, err := outFile.Write(data)
 		if err == nil {
-			log.Printf("Write new file (size %v) to %q in %v\n",
+			logger.Printf("Write new file (size %v) to %q in %v\n",
 				outCount, outFilePath, time.Now().Sub(start))
 		}
 		return err
@@ -173,20 +588,28 @@ func writeFile(data []byte, outFilePath string) error {
 	}
 }
 
-func copyFile(inFilePath, outFilePath string) error {
+func writeFileIfChanged(data []byte, outFilePath string, logger *log.Logger) error {
+	if old, err := ioutil.ReadFile(outFilePath); err == nil && bytes.Equal(old, data) {
+		logger.Println("Already exists:", outFilePath)
+		return nil
+	}
+	return writeFile(data, outFilePath, logger)
+}
+
+func copyFile(inFilePath, outFilePath string, logger *log.Logger) (result error) {
 	start := time.Now()
 	if err := ensureFolders(outFilePath); err != nil {
 		return err
 	}
 	if outFile, err := os.Create(outFilePath); err == nil {
 		defer func() {
-			forbidErr(outFile.Sync())
-			forbidErr(outFile.Close())
+			result = closeWrittenFile(outFile, result)
 		}()
 		if inFile, err := os.Open(inFilePath); err == nil {
+			defer closeFile(inFile)
 			outCount, copyErr := io.Copy(outFile, inFile)
 			if copyErr == nil {
-				log.Printf("Copied file (size %v) from %q to %q in %v\n",
+				logger.Printf("Copied file (size %v) from %q to %q in %v\n",
 					outCount, inFilePath, outFilePath, time.Now().Sub(start))
 			}
 			return copyErr
@@ -198,6 +621,18 @@ func copyFile(inFilePath, outFilePath string) error {
 	}
 }
 
+// closeWrittenFile syncs and closes a written file, returning the first error of writing, syncing or closing it,
+// ex. when the mobile library's disk is full or removed.
+func closeWrittenFile(f *os.File, err error) error {
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func ensurePathSep(path string) string {
 	if len(path) > 0 && !os.IsPathSeparator(path[len(path)-1]) {
 		return path + string(os.PathSeparator)
@@ -208,15 +643,38 @@ func ensurePathSep(path string) string {
 // Convert a FLAC file to an byte array representing an opus file
 // This requires "opusenc" to be available in the execution path
 // Ensure that this path is for known good flac file.
-func flacToOpus(path string) ([]byte, error) {
+// If picture is not nil, it replaces any pictures in the FLAC file as the front cover.
+// The encoder is killed if ctx is canceled.
+func flacToOpus(ctx context.Context, path string, picture []byte, logger *log.Logger) ([]byte, error) {
 	start := time.Now()
-	opusenc := exec.Command("opusenc", "--bitrate", "256", path, "-")
+	args := []string{"--bitrate", strconv.Itoa(opusBitrate)}
+	if picture != nil {
+		pictureFile, err := ioutil.TempFile("", "discographic-*.jpg")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.Remove(pictureFile.Name())
+		}()
+		_, err = pictureFile.Write(picture)
+		if closeErr := pictureFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--discard-pictures", "--picture", pictureFile.Name())
+	}
+	opusenc := exec.CommandContext(ctx, "opusenc", append(args, path, "-")...)
 	var out bytes.Buffer
 	opusenc.Stdout = &out
 	if err := opusenc.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	} else {
-		log.Printf("Encode opus (size %d) from %q in %v", out.Len(), path, time.Now().Sub(start))
+		logger.Printf("Encode opus (size %d) from %q in %v", out.Len(), path, time.Now().Sub(start))
 		return out.Bytes(), nil
 	}
 }
@@ -224,23 +682,35 @@ func flacToOpus(path string) ([]byte, error) {
 type encodeTask struct {
 	inPath  string
 	outPath string
+	picture []byte
+	size    int64 // size of the input file, for progress
 }
 
-func encode(tasks chan encodeTask) error {
+// encode encodes each task until tasks is closed. If ctx is canceled or a task fails, remaining tasks are skipped.
+func encode(ctx context.Context, tasks chan encodeTask, p *progress, logger *log.Logger) error {
 	parallel := runtime.NumCPU()
-	var eg errgroup.Group
+	eg, egCtx := errgroup.WithContext(ctx)
 	for i := 0; i < parallel; i++ {
 		eg.Go(func() error {
+			// the sender never blocks, even once every worker failed
+			defer func() {
+				for range tasks {
+				}
+			}()
 			for task := range tasks {
-				data, err := flacToOpus(task.inPath)
+				if egCtx.Err() != nil {
+					continue
+				}
+				data, err := flacToOpus(egCtx, task.inPath, task.picture, logger)
 				if err != nil {
 					return err
 				}
-				err = writeFile(data, task.outPath)
+				err = writeFile(data, task.outPath, logger)
 				if err != nil {
-					log.Println("writeFile err", err)
+					logger.Println("writeFile err", err)
 					return err
 				}
+				p.addDone(1, task.size)
 			}
 			return nil
 		})
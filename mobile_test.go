@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWriteFileFullDisk(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	if err := writeFile([]byte("song"), "/dev/full", logger); err == nil {
+		t.Error("writeFile to a full disk did not fail")
+	}
+	if err := copyFile("/dev/zero", "/dev/full", logger); err == nil {
+		t.Error("copyFile to a full disk did not fail")
+	}
+}
+
+func TestEncodeFailures(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	tasks := make(chan encodeTask)
+	result := make(chan error, 1)
+	go func() {
+		result <- encode(context.Background(), tasks, nil, logger)
+	}()
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 4*runtime.NumCPU(); i++ {
+			tasks <- encodeTask{inPath: "/nonexistent/song.flac", outPath: "/nonexistent/song.opus"}
+		}
+		close(tasks)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Minute):
+		t.Fatal("sending encode tasks blocked after every encode failed")
+	}
+	if err := <-result; err == nil {
+		t.Error("encode of a missing file did not fail")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// mobile library paths are the same as root library paths
+	nativeFs = "native"
+	// mobile library paths are rewritten to be safe on FAT32 and exFAT, which are case-insensitive
+	fatFs = "fat"
+	// max length of a FAT32 or exFAT file name, in UTF-16 code units
+	fatMaxNameLength = 255
+	// length of hash suffixes added to truncated or colliding file names, including the ~
+	shortHashLength = 7
+	// file in the mobile library root recording which root library file each mobile file came from
+	mobilePathsFile = ".discographic-paths.json"
+)
+
+// characters that are not allowed in FAT32 or exFAT file names
+const fatForbidden = `<>:"\|?*`
+
+// device names that windows will not allow as file names, with or without an extension
+var fatReserved = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+func checkMobileFs(fs string) error {
+	switch fs {
+	case nativeFs, fatFs:
+		return nil
+	}
+	return fmt.Errorf("unknown mobile filesystem %q, must be %q or %q", fs, nativeFs, fatFs)
+}
+
+// mobilePathKey returns the key used to compare mobile paths, which ignores case on case-insensitive filesystems.
+func mobilePathKey(fs string, path string) string {
+	if fs == fatFs {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// sanitizeMobilePath rewrites each component of a relative path so that it can be written to the filesystem.
+// The same path is always rewritten the same way.
+func sanitizeMobilePath(fs string, path string) string {
+	if fs != fatFs {
+		return path
+	}
+	components := strings.Split(path, string(os.PathSeparator))
+	for i, component := range components {
+		components[i] = sanitizeFatName(component)
+	}
+	return strings.Join(components, string(os.PathSeparator))
+}
+
+func sanitizeFatName(name string) string {
+	original := name
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(fatForbidden, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if len(name) == 0 {
+		name = "_"
+	}
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if _, ok := fatReserved[strings.ToUpper(base)]; ok {
+		base += "_"
+	}
+	if utf16Length(base+ext) > fatMaxNameLength {
+		// keep the extension, and add a hash of the original name so truncated names stay distinct
+		base = truncateUtf16(base, fatMaxNameLength-utf16Length(ext)-shortHashLength) + shortHash(original)
+	}
+	return base + ext
+}
+
+func utf16Length(s string) int {
+	length := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+func truncateUtf16(s string, max int) string {
+	length := 0
+	for i, r := range s {
+		runeLength := 1
+		if r >= 0x10000 {
+			runeLength = 2
+		}
+		if length+runeLength > max {
+			return strings.TrimRight(s[:i], ". ")
+		}
+		length += runeLength
+	}
+	return s
+}
+
+// shortHash returns a short file name safe hash of s, starting with ~
+func shortHash(s string) string {
+	hash := sha1.Sum([]byte(s))
+	return "~" + bytesToString(hash[:])[:shortHashLength-1]
+}
+
+// assignMobilePaths sets the mobile path of each file, returning the files keyed by mobilePathKey.
+// Where paths collide, files that previously had the path keep it, otherwise the first root path in sort order
+// does, and the rest get a hash suffix. previous is each mobile path key from the last sync.
+func assignMobilePaths(fs string, files []*mobileFile, previous map[string]syncedPath, logger *log.Logger) map[string]*mobileFile {
+	candidates := make(map[*mobileFile]string, len(files))
+	for _, mf := range files {
+		candidates[mf] = sanitizeMobilePath(fs, mf.defaultTarget())
+	}
+	kept := func(mf *mobileFile) bool {
+		synced, ok := previous[mobilePathKey(fs, candidates[mf])]
+		return ok && synced.RootPath == mf.rootPath
+	}
+	sorted := make([]*mobileFile, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		keptI, keptJ := kept(sorted[i]), kept(sorted[j])
+		if keptI != keptJ {
+			return keptI
+		}
+		return sorted[i].rootPath < sorted[j].rootPath
+	})
+
+	result := make(map[string]*mobileFile, len(files))
+	for _, mf := range sorted {
+		target := candidates[mf]
+		if other, ok := result[mobilePathKey(fs, target)]; ok {
+			name := filepath.Base(target)
+			dir, ext := target[:len(target)-len(name)], filepath.Ext(name)
+			suffixed := func(counter string) string {
+				base := name[:len(name)-len(ext)]
+				if fs == fatFs { // make room for the suffix
+					base = truncateUtf16(base, fatMaxNameLength-utf16Length(ext)-shortHashLength-len(counter))
+				}
+				return dir + base + shortHash(mf.rootPath) + counter + ext
+			}
+			target = suffixed("")
+			for i := 2; ; i++ {
+				if _, ok := result[mobilePathKey(fs, target)]; !ok {
+					break
+				}
+				target = suffixed(fmt.Sprint(i))
+			}
+			logger.Printf("mobile path collision: %q and %q, using %q", other.rootPath, mf.rootPath, target)
+		}
+		mf.target = target
+		result[mobilePathKey(fs, target)] = mf
+	}
+	return result
+}
+
+// syncedPath is what a sync recorded about a mobile path.
+type syncedPath struct {
+	RootPath string `json:"root_path"`
+	Version  string `json:"version,omitempty"` // empty if recorded by an older sync
+}
+
+// readMobilePaths reads each mobile path from the last sync, keyed by mobilePathKey,
+// or returns nil if there was no last sync.
+// Older syncs recorded only the root path of each mobile path.
+func readMobilePaths(fs string, mobile string, logger *log.Logger) map[string]syncedPath {
+	data, err := ioutil.ReadFile(mobile + mobilePathsFile)
+	if err != nil {
+		return nil
+	}
+	var targets map[string]json.RawMessage
+	if err := json.Unmarshal(data, &targets); err != nil {
+		logger.Println("ignoring invalid mobile paths file", err)
+		return nil
+	}
+	result := make(map[string]syncedPath, len(targets))
+	for target, raw := range targets {
+		var synced syncedPath
+		if err := json.Unmarshal(raw, &synced.RootPath); err != nil {
+			if err := json.Unmarshal(raw, &synced); err != nil {
+				logger.Println("ignoring invalid mobile paths file", err)
+				return nil
+			}
+		}
+		result[mobilePathKey(fs, target)] = synced
+	}
+	return result
+}
+
+// writeMobilePaths records the root path and version of each mobile path, for use by the next sync.
+func writeMobilePaths(mobile string, wanted map[string]*mobileFile) error {
+	targets := make(map[string]syncedPath, len(wanted))
+	for _, mf := range wanted {
+		targets[mf.target] = syncedPath{RootPath: mf.rootPath, Version: mf.version}
+	}
+	data, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mobile+mobilePathsFile, data, 0644)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssignMobilePaths(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	long := strings.Repeat("é", fatMaxNameLength-len(".mp3"))
+	tests := []struct {
+		name     string
+		files    []string // root paths, a/b.mp3 and A/B.mp3 if none
+		previous map[string]syncedPath
+		want     map[string]string // root path to mobile path
+	}{
+		{
+			name: "first root path in sort order keeps the path",
+			want: map[string]string{
+				"A/B.mp3": "A/B.mp3",
+				"a/b.mp3": "a/b" + shortHash("a/b.mp3") + ".mp3",
+			},
+		},
+		{
+			name:     "previous path is kept",
+			previous: map[string]syncedPath{"a/b.mp3": {RootPath: "a/b.mp3"}},
+			want: map[string]string{
+				"A/B.mp3": "A/B" + shortHash("A/B.mp3") + ".mp3",
+				"a/b.mp3": "a/b.mp3",
+			},
+		},
+		{
+			name:  "colliding names of the longest length are truncated",
+			files: []string{"a/" + long + ".mp3", "a/" + strings.ToUpper(long) + ".mp3"},
+			want: map[string]string{
+				"a/" + strings.ToUpper(long) + ".mp3": "a/" + strings.ToUpper(long) + ".mp3",
+				"a/" + long + ".mp3": "a/" + strings.Repeat("é", fatMaxNameLength-len(".mp3")-shortHashLength) +
+					shortHash("a/"+long+".mp3") + ".mp3",
+			},
+		},
+	}
+	for _, test := range tests {
+		if test.files == nil {
+			test.files = []string{"a/b.mp3", "A/B.mp3"}
+		}
+		var files []*mobileFile
+		for _, rootPath := range test.files {
+			files = append(files, &mobileFile{rootPath: filepath.FromSlash(rootPath), fileType: "MP3"})
+		}
+		wanted := assignMobilePaths(fatFs, files, test.previous, logger)
+		if len(wanted) != len(files) {
+			t.Errorf("%s: got %d mobile paths, want %d", test.name, len(wanted), len(files))
+		}
+		for _, mf := range files {
+			if utf16Length(filepath.Base(mf.target)) > fatMaxNameLength {
+				t.Errorf("%s: mobile path %q is longer than %d", test.name, mf.target, fatMaxNameLength)
+			}
+			if mf.target != filepath.FromSlash(test.want[filepath.ToSlash(mf.rootPath)]) {
+				t.Errorf("%s: %q got mobile path %q, want %q", test.name, mf.rootPath, mf.target, test.want[mf.rootPath])
+			}
+		}
+	}
+}
+
+func TestMobilePlanChanged(t *testing.T) {
+	mf := &mobileFile{rootPath: "a/b.flac", target: "A/B.opus", version: "v2"}
+	tests := []struct {
+		previous map[string]syncedPath
+		want     bool
+	}{
+		{previous: nil, want: false},
+		{previous: map[string]syncedPath{"a/b.opus": {RootPath: "a/b.flac"}}, want: false},
+		{previous: map[string]syncedPath{"a/b.opus": {RootPath: "a/b.flac", Version: "v2"}}, want: false},
+		{previous: map[string]syncedPath{"a/b.opus": {RootPath: "a/b.flac", Version: "v1"}}, want: true},
+		{previous: map[string]syncedPath{"a/b.opus": {RootPath: "a/c.flac", Version: "v2"}}, want: true},
+		{previous: map[string]syncedPath{}, want: true},
+	}
+	for _, test := range tests {
+		plan := &mobilePlan{args: mobileSyncArgs{fs: fatFs}, previous: test.previous}
+		if got := plan.changed(mf); got != test.want {
+			t.Errorf("changed with previous %v = %v, want %v", test.previous, got, test.want)
+		}
+	}
+}
+
+func TestReadMobilePaths(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	mobile, err := ioutil.TempDir("", "mobilepaths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mobile)
+	mobile += string(filepath.Separator)
+
+	// older syncs recorded only root paths
+	old := `{"A/B.opus": "a/b.flac"}`
+	if err := ioutil.WriteFile(mobile+mobilePathsFile, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]syncedPath{"a/b.opus": {RootPath: "a/b.flac"}}
+	if got := readMobilePaths(fatFs, mobile, logger); !reflect.DeepEqual(got, want) {
+		t.Errorf("read old mobile paths = %v, want %v", got, want)
+	}
+
+	wanted := map[string]*mobileFile{"a/b.opus": {rootPath: "a/b.flac", target: "A/B.opus", version: "v1"}}
+	if err := writeMobilePaths(mobile, wanted); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]syncedPath{"a/b.opus": {RootPath: "a/b.flac", Version: "v1"}}
+	if got := readMobilePaths(fatFs, mobile, logger); !reflect.DeepEqual(got, want) {
+		t.Errorf("read mobile paths = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/dimfeld/httptreemux/v5"
+	"github.com/shawnsmithdev/tag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mobile file is copied from the root library as is
+	originalVariant = "original"
+	// mobile file is an opus encoded copy of a FLAC file in the root library
+	opusVariant = "opus"
+	// mobile file is generated, like playlists and resized art
+	generatedVariant = "generated"
+)
+
+// mobileManifest describes the files wanted in a mobile library, for sync clients.
+type mobileManifest struct {
+	FS    string               `json:"fs"` // filesystem paths were rewritten for, see sanitizeMobilePath
+	Files []mobileManifestFile `json:"files"`
+}
+
+type mobileManifestFile struct {
+	Path    string `json:"path"`    // slash separated path relative to the mobile library
+	Hash    string `json:"hash"`    // changes whenever the file would change
+	Size    int64  `json:"size"`    // estimated size for opus and generated files
+	Variant string `json:"variant"` // original, opus, or generated
+}
+
+// mobileServer serves the files wanted in a mobile library over http.
+// Opus files are encoded on first download and cached, so that partial downloads can be resumed.
+// Each file is served with a hash of its content as its ETag, so that clients can resume partial downloads
+// with If-Range, and check downloads are complete. Encoding the same FLAC file twice does not always give
+// the same bytes, so the manifest hash alone does not identify the content.
+type mobileServer struct {
+	args  mobileSyncArgs
+	libs  *libraryHolder
+	cache string
+
+	lock   sync.Mutex
+	plan   *mobilePlan
+	hashes map[string]servedHash // keyed by absolute path
+
+	encodeLock sync.Mutex
+}
+
+// servedHash is the content hash of a served file, valid while its size and modified time are unchanged.
+type servedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+func newMobileServer(args mobileSyncArgs, libs *libraryHolder, cache string) *mobileServer {
+	return &mobileServer{args: args, libs: libs, cache: ensurePathSep(cache), hashes: make(map[string]servedHash)}
+}
+
+// replan chooses the files wanted in the mobile library again, and removes cached files that are no longer wanted.
+func (m *mobileServer) replan() (*mobilePlan, error) {
+	args := m.args
+	args.lib = m.libs.library()
+	plan, err := planMobile(args)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	m.plan = plan
+	m.lock.Unlock()
+	m.pruneCache(plan)
+	return plan, nil
+}
+
+// currentPlan returns the files wanted in the mobile library, see replan, planning them again if the library changed.
+func (m *mobileServer) currentPlan() (*mobilePlan, error) {
+	m.lock.Lock()
+	plan := m.plan
+	m.lock.Unlock()
+	if plan != nil && plan.args.lib == m.libs.library() {
+		return plan, nil
+	}
+	return m.replan()
+}
+
+// manifest lists the files wanted in the mobile library, planned again only if the library changed.
+func (m *mobileServer) manifest() (mobileManifest, error) {
+	plan, err := m.currentPlan()
+	if err != nil {
+		return mobileManifest{}, err
+	}
+	result := mobileManifest{FS: m.args.fs}
+	for _, key := range plan.keys() {
+		mf := plan.wanted[key]
+		variant := originalVariant
+		if mf.generate != nil {
+			variant = generatedVariant
+		} else if mf.fileType == string(tag.FLAC) {
+			variant = opusVariant
+		}
+		result.Files = append(result.Files, mobileManifestFile{
+			Path:    filepath.ToSlash(mf.target),
+			Hash:    mf.version,
+			Size:    mf.size,
+			Variant: variant,
+		})
+	}
+	return result, nil
+}
+
+func (m *mobileServer) cachePath(mf *mobileFile) string {
+	return m.cache + mf.version + ".opus"
+}
+
+// encodeCached returns the path of the cached opus encoding of a FLAC file, encoding it if not yet cached.
+func (m *mobileServer) encodeCached(ctx context.Context, plan *mobilePlan, mf *mobileFile) (string, error) {
+	m.encodeLock.Lock()
+	defer m.encodeLock.Unlock()
+	cached := m.cachePath(mf)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	data, err := flacToOpus(ctx, m.args.root+mf.rootPath, plan.pictures.get(mf), m.args.logger)
+	if err != nil {
+		return "", err
+	}
+	// write then rename, so a failed write is never served
+	if err := writeFile(data, cached+".tmp", m.args.logger); err != nil {
+		return "", err
+	}
+	return cached, os.Rename(cached+".tmp", cached)
+}
+
+func (m *mobileServer) pruneCache(plan *mobilePlan) {
+	files, err := ioutil.ReadDir(m.cache)
+	if err != nil {
+		return
+	}
+	wanted := make(map[string]struct{})
+	for _, mf := range plan.wanted {
+		wanted[filepath.Base(m.cachePath(mf))] = struct{}{}
+	}
+	for _, file := range files {
+		if _, ok := wanted[file.Name()]; ok || !strings.HasSuffix(file.Name(), ".opus") {
+			continue
+		}
+		if err := os.Remove(m.cache + file.Name()); err != nil {
+			m.args.logger.Println(err)
+		}
+		m.lock.Lock()
+		delete(m.hashes, m.cache+file.Name())
+		m.lock.Unlock()
+	}
+}
+
+// fileHash returns the content hash of a file, only reading the file again if it changed since the last call.
+func (m *mobileServer) fileHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	m.lock.Lock()
+	known, ok := m.hashes[path]
+	m.lock.Unlock()
+	if ok && known.size == info.Size() && known.modTime.Equal(info.ModTime()) {
+		return known.hash, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer closeFile(file)
+	hash, err := contentHash(file)
+	if err != nil {
+		return "", err
+	}
+	m.lock.Lock()
+	m.hashes[path] = servedHash{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	m.lock.Unlock()
+	return hash, nil
+}
+
+// contentHash returns a hash of all data read from r, used as the ETag of mobile files.
+func contentHash(r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return bytesToString(h.Sum(nil)), nil
+}
+
+// Handler for the manifest of files wanted in the mobile library
+// clearWriteDeadline removes the server write timeout of a mobile response, since planning the mobile library,
+// encoding FLAC files, waiting for other encodings and sending large files can take longer.
+func clearWriteDeadline(writer http.ResponseWriter, logger *log.Logger) {
+	if err := http.NewResponseController(writer).SetWriteDeadline(time.Time{}); err != nil {
+		logger.Println(err)
+	}
+}
+
+func mobileManifestHandler(mobile *mobileServer, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		clearWriteDeadline(writer, logger)
+		manifest, err := mobile.manifest()
+		if err != nil {
+			logger.Println(err)
+			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		logger.Println("serving mobile manifest, file_count:", len(manifest.Files))
+		buf := new(bytes.Buffer)
+		err = json.NewEncoder(buf).Encode(manifest)
+		forbidErr(err)
+		writer.Header().Set(contentTypeHeader, jsonMime)
+		_, err = writer.Write(buf.Bytes())
+		forbidErr(err)
+	}
+}
+
+// Handler for files wanted in the mobile library, by mobile path. Supports range requests.
+func mobileFileHandler(mobile *mobileServer, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		clearWriteDeadline(writer, logger)
+		pathArg, ok := httptreemux.ContextParams(req.Context())["path"]
+		if !ok {
+			writeYourErr(writer, logger, fmt.Errorf("path requires 1 argument (mobile path)"))
+			return
+		}
+		plan, err := mobile.currentPlan()
+		if err != nil {
+			logger.Println(err)
+			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		mf, ok := plan.wanted[mobilePathKey(mobile.args.fs, filepath.FromSlash(pathArg))]
+		if !ok {
+			writeNotFoundErr(writer, logger, fmt.Errorf("unknown mobile file: %v", pathArg))
+			return
+		}
+		logger.Printf("serving mobile file %q", pathArg)
+		if mf.generate != nil {
+			data, err := mf.generate()
+			if err == nil {
+				var hash string
+				hash, err = contentHash(bytes.NewReader(data))
+				writer.Header().Set("ETag", `"`+hash+`"`)
+			}
+			if err != nil {
+				logger.Println(err)
+				http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+				return
+			}
+			http.ServeContent(writer, req, mf.target, time.Time{}, bytes.NewReader(data))
+			return
+		}
+		path := mobile.args.root + mf.rootPath
+		if mf.fileType == string(tag.FLAC) {
+			path, err = mobile.encodeCached(req.Context(), plan, mf)
+		}
+		var hash string
+		if err == nil {
+			hash, err = mobile.fileHash(path)
+		}
+		if err != nil {
+			logger.Println(err)
+			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		// ServeFile checks If-Range against the ETag
+		writer.Header().Set("ETag", `"`+hash+`"`)
+		http.ServeFile(writer, req, path)
+	}
+}
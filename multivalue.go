@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"strings"
+)
+
+const (
+	// default delimiters that split artist, album artist, composer and genre tags into multiple values
+	defaultTagDelimiters = ";| feat. | ft. | featuring "
+	// separates multiple values when joined back into a single string field
+	multiValueSeparator = "; "
+)
+
+// parseTagDelimiters parses | separated delimiters, ex. "; | feat. ", keeping any spaces around each one.
+func parseTagDelimiters(delimiters string) []string {
+	var result []string
+	for _, delimiter := range strings.Split(delimiters, "|") {
+		if len(strings.TrimSpace(delimiter)) > 0 {
+			result = append(result, delimiter)
+		}
+	}
+	return result
+}
+
+// splitTagValues splits each value at any of the delimiters, ignoring case,
+// and returns the trimmed values without empty or repeated ones.
+func splitTagValues(values []string, delimiters []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, part := range splitTagValue(value, delimiters) {
+			if key := strings.ToLower(part); !seen[key] {
+				seen[key] = true
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+func splitTagValue(value string, delimiters []string) []string {
+	for i := 0; i < len(value); i++ {
+		for _, delimiter := range delimiters {
+			if end := i + len(delimiter); end <= len(value) && strings.EqualFold(value[i:end], delimiter) {
+				return append(splitTagValue(value[:i], nil), splitTagValue(value[end:], delimiters)...)
+			}
+		}
+	}
+	if value = strings.TrimSpace(value); len(value) > 0 {
+		return []string{value}
+	}
+	return nil
+}
+
+// multiValueTags are the repeated or null separated values of a song's artist, album artist, composer and genre tags,
+// read from the file directly, since the tag library only returns one value of each.
+// Only FLAC Vorbis comments and ID3v2.3/ID3v2.4 frames are read, other formats only have delimiters to split on.
+type multiValueTags struct {
+	artists, albumArtists, composers, genres, performers []string
+}
+
+func readMultiValueTags(path string, meta tag.Metadata) multiValueTags {
+	var result multiValueTags
+	switch {
+	case meta.FileType() == tag.FLAC:
+		comments, err := flacVorbisComments(path)
+		if err != nil || comments == nil {
+			return result
+		}
+		// same as the tag library, performer is preferred to artist, which is then the composer
+		result.artists = comments["artist"]
+		result.composers = comments["composer"]
+		if performers := comments["performer"]; len(performers) > 0 {
+			result.artists = performers
+			if len(result.composers) == 0 {
+				result.composers = comments["artist"]
+			}
+		}
+		result.albumArtists = comments["albumartist"]
+		result.genres = comments["genre"]
+		result.performers = comments["performer"]
+	case meta.FileType() == OPUS:
+		if opus, ok := meta.(*opusMeta); ok {
+			result.artists = opus.comments["artist"]
+			result.albumArtists = opus.comments["albumartist"]
+			result.composers = opus.comments["composer"]
+			result.genres = opus.comments["genre"]
+			result.performers = opus.comments["performer"]
+		}
+	case meta.Format() == tag.ID3v2_3 || meta.Format() == tag.ID3v2_4:
+		frames, err := id3TextFrames(path)
+		if err != nil || frames == nil {
+			return result
+		}
+		result.artists = frames["TPE1"]
+		result.albumArtists = frames["TPE2"]
+		result.composers = frames["TCOM"]
+		result.genres = frames["TCON"]
+		result.performers = id3Performers(frames["TMCL"])
+	}
+	return result
+}
+
+// copyMultiValueMetadata fills the artist, album artist, composer and genre lists,
+// and joins multiple values into the single string fields.
+func (s *Song) copyMultiValueMetadata(meta tag.Metadata, delimiters []string) {
+	tags := readMultiValueTags(s.Path, meta)
+	s.Artists = multiValue(&s.Artist, tags.artists, delimiters)
+	s.AlbumArtists = multiValue(&s.AlbumArtist, tags.albumArtists, delimiters)
+	s.Composers = multiValue(&s.Composer, tags.composers, delimiters)
+	s.Genres = multiValue(&s.Genre, tags.genres, delimiters)
+	if len(tags.performers) > 0 {
+		s.Performers = tags.performers
+	}
+	s.Performers = splitTagValues(s.Performers, delimiters)
+}
+
+// id3Performers returns each musician of an ID3v2.4 musician credits list, which alternates role and name,
+// as name (role), ex. Martha Argerich (piano)
+func id3Performers(credits []string) []string {
+	var result []string
+	for i := 0; i+1 < len(credits); i += 2 {
+		result = append(result, fmt.Sprintf("%s (%s)", credits[i+1], credits[i]))
+	}
+	return result
+}
+
+// multiValue splits the tag values, or the single string field if the tag had no more than one value.
+// The single string field is replaced by the tag values joined together, if there were more than one.
+func multiValue(field *string, values []string, delimiters []string) []string {
+	if len(values) > 1 {
+		if result := splitTagValues(values, delimiters); len(result) > 0 {
+			*field = strings.Join(result, multiValueSeparator)
+			return result
+		}
+	}
+	return splitTagValues([]string{*field}, delimiters)
+}
+
+// songAlbumArtists returns each album artist of a song, or each artist if it has no album artist.
+// Songs read before artists were split into lists use the single string fields.
+func songAlbumArtists(song *Song) []string {
+	if len(song.AlbumArtists) > 0 {
+		return song.AlbumArtists
+	}
+	if len(song.AlbumArtist) > 0 {
+		return []string{song.AlbumArtist}
+	}
+	if len(song.Artists) > 0 {
+		return song.Artists
+	}
+	return []string{song.Artist}
+}
+
+// songArtists returns each artist and album artist of a song, without repeats.
+func songArtists(song *Song) []string {
+	artists := song.Artists
+	if len(artists) == 0 && len(song.Artist) > 0 {
+		artists = []string{song.Artist}
+	}
+	albumArtists := song.AlbumArtists
+	if len(albumArtists) == 0 && len(song.AlbumArtist) > 0 {
+		albumArtists = []string{song.AlbumArtist}
+	}
+	return splitTagValues(append(append([]string{}, artists...), albumArtists...), nil)
+}
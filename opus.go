@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oggPageHeader = "OggS"
+	// fixed part of an ogg page header, before the segment table
+	oggPageHeaderSize = 27
+	// offset of the page checksum in an ogg page header
+	oggCrcStart = 22
+	// first packet of an opus stream
+	opusHeadMagic = "OpusHead"
+	// opus granule positions always count samples at 48 kHz
+	opusSampleRate = 48000
+)
+
+// oggCrcTable is the CRC-32 table used by ogg pages, polynomial 0x04c11db7 without bit reflection.
+var oggCrcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCrc(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = crc<<8 ^ oggCrcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// opusDuration reads every page of an ogg opus file, checking page checksums, and returns the playback duration.
+func opusDuration(path string) (time.Duration, error) {
+	opusFile, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile(opusFile)
+	reader := bufio.NewReader(opusFile)
+
+	var (
+		header      [oggPageHeaderSize]byte
+		segments    [255]byte
+		preSkip     = int64(-1)
+		lastGranule int64
+		pages       int
+	)
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("page %v of %q is truncated: %v", pages, path, err)
+		}
+		if oggPageHeader != string(header[:len(oggPageHeader)]) {
+			return 0, fmt.Errorf("page %v of %q has no ogg page header", pages, path)
+		}
+		segmentTable := segments[:header[oggPageHeaderSize-1]]
+		if _, err := io.ReadFull(reader, segmentTable); err != nil {
+			return 0, fmt.Errorf("page %v of %q is truncated: %v", pages, path, err)
+		}
+		size := 0
+		for _, segment := range segmentTable {
+			size += int(segment)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return 0, fmt.Errorf("page %v of %q is truncated: %v", pages, path, err)
+		}
+
+		expected := binary.LittleEndian.Uint32(header[oggCrcStart:])
+		binary.LittleEndian.PutUint32(header[oggCrcStart:], 0)
+		crc := oggCrc(oggCrc(oggCrc(0, header[:]), segmentTable), data)
+		if crc != expected {
+			return 0, fmt.Errorf("page %v of %q has a bad checksum", pages, path)
+		}
+
+		if pages == 0 {
+			if len(data) < 12 || !bytes.HasPrefix(data, []byte(opusHeadMagic)) {
+				return 0, fmt.Errorf("%q is not an ogg opus file", path)
+			}
+			preSkip = int64(binary.LittleEndian.Uint16(data[10:12]))
+		}
+		if granule := int64(binary.LittleEndian.Uint64(header[6:14])); granule > 0 {
+			lastGranule = granule
+		}
+		pages++
+	}
+	if preSkip < 0 {
+		return 0, fmt.Errorf("%q is empty", path)
+	}
+	samples := lastGranule - preSkip
+	return time.Duration(samples) * time.Second / opusSampleRate, nil
+}
+
+const (
+	// second packet of an opus stream, followed by vorbis comments without a framing bit
+	opusTagsMagic = "OpusTags"
+	// largest OpusTags packet read, which may hold embedded art
+	maxOpusTagsSize = 16 * megabyte
+)
+
+// opusMeta is the metadata of an ogg opus file, which the tag library does not read, from its vorbis comments.
+// Embedded art is not read.
+type opusMeta struct {
+	comments map[string][]string // lowercase name to values, see parseVorbisComments
+}
+
+// readOpusMeta reads the OpusTags comments of an ogg opus file, and the sha1 hash of its audio,
+// the packets after the OpusHead and OpusTags header packets. Page headers are not hashed, since their
+// sequence numbers and checksums change when the header packets are rewritten with other tags.
+func readOpusMeta(opusFile io.Reader) (*opusMeta, songHash, error) {
+	var (
+		hash     songHash
+		header   [oggPageHeaderSize]byte
+		segments [255]byte
+		packets  [][]byte
+		packet   []byte
+		result   *opusMeta
+	)
+	audio := sha1.New()
+	reader := bufio.NewReader(opusFile)
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF && result != nil {
+			break
+		} else if err != nil {
+			return nil, hash, err
+		}
+		if oggPageHeader != string(header[:len(oggPageHeader)]) {
+			return nil, hash, fmt.Errorf("not an ogg file")
+		}
+		segmentTable := segments[:header[oggPageHeaderSize-1]]
+		if _, err := io.ReadFull(reader, segmentTable); err != nil {
+			return nil, hash, err
+		}
+		for _, segment := range segmentTable {
+			data := make([]byte, segment)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, hash, err
+			}
+			if len(packets) == 2 {
+				_, _ = audio.Write(data)
+				continue
+			}
+			packet = append(packet, data...)
+			if segment < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+		if len(packet) > maxOpusTagsSize {
+			return nil, hash, fmt.Errorf("opus header packet is larger than %v bytes", maxOpusTagsSize)
+		}
+		if result != nil || len(packets) < 2 {
+			continue
+		}
+		if !bytes.HasPrefix(packets[0], []byte(opusHeadMagic)) || !bytes.HasPrefix(packets[1], []byte(opusTagsMagic)) {
+			return nil, hash, fmt.Errorf("not an ogg opus file")
+		}
+		comments, err := parseVorbisComments(packets[1][len(opusTagsMagic):])
+		if err != nil {
+			return nil, hash, err
+		}
+		result = &opusMeta{comments: comments}
+	}
+	copy(hash[:], audio.Sum(nil))
+	return result, hash, nil
+}
+
+func (m *opusMeta) first(name string) string {
+	if values := m.comments[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (m *opusMeta) firstInt(name string) int {
+	result, _ := strconv.Atoi(strings.TrimSpace(m.first(name)))
+	return result
+}
+
+func (m *opusMeta) Format() tag.Format     { return tag.VORBIS }
+func (m *opusMeta) FileType() tag.FileType { return OPUS }
+func (m *opusMeta) Title() string          { return m.first("title") }
+func (m *opusMeta) Album() string          { return m.first("album") }
+func (m *opusMeta) Artist() string         { return m.first("artist") }
+func (m *opusMeta) AlbumArtist() string    { return m.first("albumartist") }
+func (m *opusMeta) Composer() string       { return m.first("composer") }
+func (m *opusMeta) Date() string           { return m.first("date") }
+func (m *opusMeta) Genre() string          { return m.first("genre") }
+func (m *opusMeta) Track() (int, int)      { return m.firstInt("tracknumber"), m.firstInt("tracktotal") }
+func (m *opusMeta) Disc() (int, int)       { return m.firstInt("discnumber"), m.firstInt("disctotal") }
+func (m *opusMeta) Picture() *tag.Picture  { return nil }
+func (m *opusMeta) Lyrics() string         { return m.first("lyrics") }
+func (m *opusMeta) Comment() string        { return m.first("comment") }
+
+func (m *opusMeta) Year() int {
+	if date := m.Date(); len(date) >= 4 {
+		year, _ := strconv.Atoi(date[:4])
+		return year
+	}
+	return 0
+}
+
+// Raw returns the first value of each comment, by lowercase name.
+func (m *opusMeta) Raw() map[string]interface{} {
+	result := make(map[string]interface{}, len(m.comments))
+	for name := range m.comments {
+		result[name] = m.first(name)
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testOggPage returns an ogg page of whole packets, with its checksum.
+func testOggPage(sequence uint32, granule int64, packets ...[]byte) []byte {
+	var segmentTable, data []byte
+	for _, packet := range packets {
+		for i := 0; i+255 <= len(packet); i += 255 {
+			segmentTable = append(segmentTable, 255)
+		}
+		segmentTable = append(segmentTable, byte(len(packet)%255))
+		data = append(data, packet...)
+	}
+	header := make([]byte, oggPageHeaderSize)
+	copy(header, oggPageHeader)
+	binary.LittleEndian.PutUint64(header[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(header[18:], sequence)
+	header[oggPageHeaderSize-1] = byte(len(segmentTable))
+	binary.LittleEndian.PutUint32(header[oggCrcStart:], oggCrc(oggCrc(oggCrc(0, header), segmentTable), data))
+	return append(append(header, segmentTable...), data...)
+}
+
+// testOpusHead returns an OpusHead packet of a stereo stream with a pre-skip.
+func testOpusHead(preSkip uint16) []byte {
+	head := append([]byte(opusHeadMagic), 1, 2, 0, 0, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(head[10:], preSkip)
+	return head
+}
+
+func testOpusTags(comments ...string) []byte {
+	return append([]byte(opusTagsMagic), joinVorbisComments("test", comments)...)
+}
+
+func TestReadOpusMeta(t *testing.T) {
+	head := testOpusHead(312)
+	audio := [][]byte{bytes.Repeat([]byte{0xFC, 1}, 100), bytes.Repeat([]byte{0xFC, 2}, 200)}
+	tests := []struct {
+		name     string
+		file     []byte
+		title    string
+		sameHash bool
+	}{
+		{
+			name: "audio on its own pages",
+			file: bytes.Join([][]byte{
+				testOggPage(0, 0, head),
+				testOggPage(1, 0, testOpusTags("TITLE=Song", "ARTIST=Muse")),
+				testOggPage(2, 960, audio[0]),
+				testOggPage(3, 1920, audio[1]),
+			}, nil),
+			title: "Song", sameHash: true,
+		},
+		{
+			name: "other tags and page sequence numbers",
+			file: bytes.Join([][]byte{
+				testOggPage(0, 0, head),
+				testOggPage(1, 0, testOpusTags("TITLE=Another Song", "COMMENT="+strings.Repeat("x", 600))),
+				testOggPage(5, 960, audio[0]),
+				testOggPage(6, 1920, audio[1]),
+			}, nil),
+			title: "Another Song", sameHash: true,
+		},
+		{
+			name: "audio on the tags page",
+			file: bytes.Join([][]byte{
+				testOggPage(0, 0, head),
+				testOggPage(1, 960, testOpusTags("TITLE=Song"), audio[0]),
+				testOggPage(2, 1920, audio[1]),
+			}, nil),
+			title: "Song", sameHash: true,
+		},
+		{
+			name: "other audio",
+			file: bytes.Join([][]byte{
+				testOggPage(0, 0, head),
+				testOggPage(1, 0, testOpusTags("TITLE=Song")),
+				testOggPage(2, 960, audio[1]),
+				testOggPage(3, 1920, audio[0]),
+			}, nil),
+			title: "Song",
+		},
+	}
+	_, want, err := readOpusMeta(bytes.NewReader(tests[0].file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		meta, hash, err := readOpusMeta(bytes.NewReader(test.file))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if meta.Title() != test.title {
+			t.Errorf("%s: got title %q, want %q", test.name, meta.Title(), test.title)
+		}
+		if (hash == want) != test.sameHash {
+			t.Errorf("%s: got hash %v, audio on its own pages has %v", test.name, hash, want)
+		}
+	}
+}
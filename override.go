@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// metadataOverride is song fields to show instead of the tagged ones, by json name, ex. {"date": "1999"}.
+// Overrides are keyed by song hash, which does not change when tags do, so they survive rescans.
+// Multiple artists, album artists, composers, genres or performers are separated by ;
+type metadataOverride map[string]string
+
+// withOverride returns a copy of the song with the override applied instead of any previous override.
+// The tagged values of overridden fields are kept in Song.Tagged and Song.TaggedValues, so that they can be restored.
+func (s *Song) withOverride(override metadataOverride) (*Song, error) {
+	result := *s
+	for field, value := range s.Tagged {
+		forbidErr(setSongField(&result, field, value))
+		if tagged, ok := s.TaggedValues[field]; ok {
+			*songFieldValues(&result, field) = tagged
+		}
+	}
+	result.Overrides, result.Tagged, result.TaggedValues = nil, nil, nil
+	if len(override) == 0 {
+		return &result, nil
+	}
+	result.Overrides = make(map[string]string, len(override))
+	result.Tagged = make(map[string]string, len(override))
+	for field, value := range override {
+		tagged, ok := songField(&result, field)
+		if !ok {
+			return s, fmt.Errorf("unknown song field %q", field)
+		}
+		values := songFieldValues(&result, field)
+		var taggedValues []string
+		if values != nil {
+			taggedValues = *values
+		}
+		if err := setSongField(&result, field, value); err != nil {
+			return s, err
+		}
+		result.Overrides[field] = value
+		result.Tagged[field] = tagged
+		if values != nil {
+			if result.TaggedValues == nil {
+				result.TaggedValues = make(map[string][]string)
+			}
+			result.TaggedValues[field] = taggedValues
+		}
+	}
+	return &result, nil
+}
+
+// songFieldValues returns the values of a multi-valued song field by its json name, or nil for other fields.
+func songFieldValues(song *Song, field string) *[]string {
+	switch field {
+	case "artist":
+		return &song.Artists
+	case "album_artist":
+		return &song.AlbumArtists
+	case "composer":
+		return &song.Composers
+	case "genre":
+		return &song.Genres
+	case "performers":
+		return &song.Performers
+	}
+	return nil
+}
+
+// setSongField sets a song field by its json name, see songField. Multi-valued fields are split by ;
+func setSongField(song *Song, field, value string) error {
+	var err error
+	split := func(value string) []string {
+		return splitTagValues([]string{value}, []string{";"})
+	}
+	switch field {
+	case "album":
+		song.Album = value
+	case "artist":
+		song.Artist, song.Artists = value, split(value)
+	case "album_artist":
+		song.AlbumArtist, song.AlbumArtists = value, split(value)
+	case "composer":
+		song.Composer, song.Composers = value, split(value)
+	case "genre":
+		song.Genre, song.Genres = value, split(value)
+	case "title":
+		song.Title = value
+	case "comment":
+		song.Comment = value
+	case "date":
+		song.Date = value
+		song.parseDates()
+	case "original_date":
+		song.OriginalDate = value
+		song.parseDates()
+	case "label":
+		song.Label = value
+	case "catalog_number":
+		song.CatalogNumber = value
+	case "isrc":
+		song.ISRC = value
+	case "musicbrainz_album_id":
+		song.MusicBrainzAlbumID = value
+	case "artist_sort":
+		song.ArtistSort = value
+	case "album_artist_sort":
+		song.AlbumArtistSort = value
+	case "track":
+		song.Track, err = parseOverrideInt(field, value)
+	case "disc":
+		song.Disc, err = parseOverrideInt(field, value)
+	case "disc_subtitle":
+		song.DiscSubtitle = value
+	case "bpm":
+		song.BPM, err = parseOverrideInt(field, value)
+	case "compilation":
+		song.Compilation = strings.EqualFold(value, "true") || value == "1"
+	case "work":
+		song.Work = value
+	case "movement_name":
+		song.MovementName = value
+	case "movement":
+		song.Movement, err = parseOverrideInt(field, value)
+	case "conductor":
+		song.Conductor = value
+	case "orchestra":
+		song.Orchestra = value
+	case "performers":
+		song.Performers = split(value)
+	default:
+		return fmt.Errorf("song field %q can not be overridden", field)
+	}
+	return err
+}
+
+func parseOverrideInt(field, value string) (int, error) {
+	var result int
+	if len(value) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Sscanf(value, "%d", &result); err != nil || result < 0 {
+		return 0, fmt.Errorf("song field %q must be a number, not %q", field, value)
+	}
+	return result, nil
+}
+
+func (l library) metadataOverrides() map[songHash]metadataOverride {
+	return l.Overrides
+}
+
+// withOverrides returns a copy of the library, sharing art, with the overrides applied to its songs,
+// instead of any previous overrides. Overrides of songs not in the library are kept, in case they come back.
+func (l library) withOverrides(overrides map[songHash]metadataOverride, logger *log.Logger) Library {
+	result := &library{
+		SongMap:     make(map[songHash]*Song, len(l.SongMap)),
+		ArtMap:      l.ArtMap,
+		Overrides:   overrides,
+		Delimiters:  l.Delimiters,
+		HashVersion: l.HashVersion,
+	}
+	for hash, song := range l.SongMap {
+		if override, ok := overrides[hash]; ok || len(song.Tagged) > 0 {
+			overridden, err := song.withOverride(override)
+			if err != nil {
+				logger.Printf("ignoring metadata override of %q: %v", song.Path, err)
+			}
+			song = overridden
+		}
+		result.SongMap[hash] = song
+	}
+	return result
+}
+
+// movedOverrides returns the metadata overrides of previous to apply to lib, a library of the same files.
+// Where the song of an override has another hash in lib, found by path, the override is moved to the new hash,
+// so that overrides survive changes to how songs are hashed, see currentHashVersion.
+func movedOverrides(previous, lib Library, logger *log.Logger) map[songHash]metadataOverride {
+	overrides := previous.metadataOverrides()
+	if len(overrides) == 0 {
+		return overrides
+	}
+	byPath := make(map[string]songHash)
+	forbidErr(lib.songs(func(song *Song) error {
+		byPath[song.Path] = song.Hash
+		for _, path := range song.DuplicatePaths {
+			byPath[path] = song.Hash
+		}
+		return nil
+	}))
+	result := make(map[songHash]metadataOverride, len(overrides))
+	for hash, override := range overrides {
+		result[hash] = override
+	}
+	for hash, override := range overrides {
+		song := previous.findSong(hash)
+		if song == nil || lib.findSong(hash) != nil {
+			continue
+		}
+		newHash, ok := byPath[song.Path]
+		if _, overridden := result[newHash]; !ok || overridden {
+			continue
+		}
+		logger.Printf("moving metadata override of %q from hash %v to %v", song.Path, hash, newHash)
+		delete(result, hash)
+		result[newHash] = override
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithOverrideRestoresTaggedValues(t *testing.T) {
+	tagged := &Song{
+		Artist:  "Jay-Z feat. Beyoncé",
+		Artists: []string{"Jay-Z", "Beyoncé"},
+		Genre:   "Hip Hop",
+		Date:    "2003",
+	}
+	tagged.parseDates()
+	overrides := []metadataOverride{
+		{"artist": "Jay-Z; Beyoncé Knowles", "date": "2003-06"},
+		{"artist": "Shawn Carter"},
+		{"genre": "Rap"},
+	}
+	song := tagged
+	for _, override := range overrides {
+		var err error
+		if song, err = song.withOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if song.Genre != "Rap" || song.Artist != "Jay-Z feat. Beyoncé" || song.Date != "2003" {
+		t.Errorf("got genre %q, artist %q and date %q after overrides", song.Genre, song.Artist, song.Date)
+	}
+	if song.Tagged["genre"] != "Hip Hop" {
+		t.Errorf("got tagged genre %q, want %q", song.Tagged["genre"], "Hip Hop")
+	}
+
+	restored, err := song.withOverride(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Artist != tagged.Artist || strings.Join(restored.Artists, ",") != "Jay-Z,Beyoncé" {
+		t.Errorf("restored artist %q %q, want %q %q", restored.Artist, restored.Artists, tagged.Artist, tagged.Artists)
+	}
+	if restored.Genre != "Hip Hop" || restored.Date != "2003" || restored.ParsedDate.String() != "2003" {
+		t.Errorf("restored genre %q and date %q", restored.Genre, restored.Date)
+	}
+	if restored.Tagged != nil || restored.TaggedValues != nil || restored.Overrides != nil {
+		t.Errorf("restored song still has overrides %v, tagged %v %v",
+			restored.Overrides, restored.Tagged, restored.TaggedValues)
+	}
+}
+
+func TestReadSongFields(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    map[string]string // "<nil>" for nil values
+		wantErr bool
+	}{
+		{body: `{"date": "1999", "track": 3, "compilation": true, "bpm": 120.5, "genre": null}`,
+			want: map[string]string{"date": "1999", "track": "3", "compilation": "true", "bpm": "120.5", "genre": "<nil>"}},
+		{body: `{}`, want: map[string]string{}},
+		{body: `{"artist": ["Jay-Z", "Beyoncé"]}`, wantErr: true},
+		{body: `{"artist": {"name": "Jay-Z"}}`, wantErr: true},
+		{body: `["date"]`, wantErr: true},
+		{body: `{"date": `, wantErr: true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("PUT", "/music/metadata/x", strings.NewReader(test.body))
+		fields, err := readSongFields(req)
+		if (err != nil) != test.wantErr {
+			t.Errorf("readSongFields(%s) error = %v, want error %v", test.body, err, test.wantErr)
+			continue
+		}
+		if len(fields) != len(test.want) {
+			t.Errorf("readSongFields(%s) = %v, want %v", test.body, fields, test.want)
+		}
+		for field, want := range test.want {
+			got := "<nil>"
+			if fields[field] != nil {
+				got = *fields[field]
+			}
+			if got != want {
+				t.Errorf("readSongFields(%s) %q = %q, want %q", test.body, field, got, want)
+			}
+		}
+	}
+}
+
+func TestMovedOverrides(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	oldHash, newHash, otherHash, goneHash := songHash{1}, songHash{2}, songHash{3}, songHash{4}
+	previous := newLibrary()
+	previous.SongMap[oldHash] = &Song{Hash: oldHash, Path: "/music/a.mp3"}
+	previous.SongMap[otherHash] = &Song{Hash: otherHash, Path: "/music/b.flac"}
+	previous.Overrides = map[songHash]metadataOverride{
+		oldHash:   {"genre": "Rock"},
+		otherHash: {"genre": "Pop"},
+		goneHash:  {"genre": "Jazz"},
+	}
+	lib := newLibrary()
+	lib.SongMap[newHash] = &Song{Hash: newHash, Path: "/music/a.mp3"}
+	lib.SongMap[otherHash] = &Song{Hash: otherHash, Path: "/music/b.flac"}
+
+	got := movedOverrides(previous, lib, logger)
+	want := map[songHash]metadataOverride{
+		newHash:   {"genre": "Rock"},
+		otherHash: {"genre": "Pop"},
+		goneHash:  {"genre": "Jazz"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got overrides %v, want %v", got, want)
+	}
+	for hash, override := range want {
+		if got[hash]["genre"] != override["genre"] {
+			t.Errorf("got override %v of %v, want %v", got[hash], hash, override)
+		}
+	}
+	if len(previous.Overrides) != 3 || previous.Overrides[oldHash] == nil {
+		t.Errorf("overrides of previous library changed to %v", previous.Overrides)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isPlaylist returns true if the path looks like an m3u or m3u8 playlist file.
+func isPlaylist(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return true
+	}
+	return false
+}
+
+// readPlaylist reads an m3u or m3u8 playlist, returning the paths of its entries.
+// Relative entries are resolved against the folder of the playlist, and urls are skipped.
+func readPlaylist(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	var result []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.Contains(line, "://") {
+			continue
+		}
+		entry := filepath.FromSlash(strings.Replace(line, "\\", "/", -1))
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(dir, entry)
+		}
+		result = append(result, filepath.Clean(entry))
+	}
+	return result, nil
+}
+
+// matchesPlaylist returns true if the playlist root path or its name without extension matches
+// any of the comma separated glob patterns, ignoring case.
+func matchesPlaylist(patterns string, rootPath string) bool {
+	name := filepath.Base(rootPath)
+	name = name[:len(name)-len(filepath.Ext(name))]
+	for _, pattern := range strings.Split(strings.ToLower(patterns), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		for _, candidate := range []string{strings.ToLower(rootPath), strings.ToLower(name)} {
+			if ok, _ := filepath.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// albumPlaylists returns a playlist for each album, placed in the album folder.
+func albumPlaylists(albums []mobileAlbum) []*mobileFile {
+	var result []*mobileFile
+	for _, album := range albums {
+		var entries []string
+		for _, song := range album.songs {
+			entries = append(entries, song.Path)
+		}
+		name := strings.Replace(album.songs[0].Album, string(os.PathSeparator), "_", -1) + ".m3u8"
+		result = append(result, &mobileFile{
+			rootPath: filepath.Join(album.dir, name),
+			fileType: "M3U8",
+			entries:  entries,
+		})
+	}
+	return result
+}
+
+// m3u8 returns the data of a playlist with paths relative to the folder of the playlist file.
+// Synced files are keyed by their absolute root library path, and entries that were not synced are left out.
+func m3u8(playlist *mobileFile, synced map[string]*mobileFile) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	dir := filepath.Dir(playlist.target)
+	for _, entry := range playlist.entries {
+		mf, ok := synced[entry]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(dir, mf.target)
+		if err != nil {
+			continue
+		}
+		if mf.song != nil {
+			fmt.Fprintf(&buf, "#EXTINF:-1,%s - %s\n", mf.song.Artist, mf.song.Title)
+		}
+		buf.WriteString(filepath.ToSlash(rel))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
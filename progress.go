@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// number of finished progress reports kept after their job is done
+const maxFinishedProgress = 10
+
+// progressReport is a snapshot of the progress of a long running job, like a library scan or mobile sync.
+type progressReport struct {
+	Name           string    `json:"name"`
+	Phase          string    `json:"phase,omitempty"`
+	Done           int       `json:"done"`
+	Total          int       `json:"total"`
+	DoneBytes      int64     `json:"done_bytes"`
+	TotalBytes     int64     `json:"total_bytes"`
+	BytesPerSecond float64   `json:"bytes_per_second"`
+	Started        time.Time `json:"started"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	EtaSeconds     float64   `json:"eta_seconds,omitempty"`
+	Finished       bool      `json:"finished"`
+}
+
+// progress tracks counts and bytes done of a long running job. All methods are safe to call on a nil progress.
+type progress struct {
+	lock   sync.Mutex
+	report progressReport
+	done   chan struct{}
+	ended  time.Time
+	logger *log.Logger // logs the final progress when finished, if not nil
+}
+
+func (p *progress) setPhase(phase string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.report.Phase = phase
+}
+
+func (p *progress) addTotal(count int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.report.Total += count
+	p.report.TotalBytes += bytes
+}
+
+func (p *progress) addDone(count int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.report.Done += count
+	p.report.DoneBytes += bytes
+}
+
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	finished := p.report.Finished
+	if !finished {
+		p.report.Finished = true
+		p.ended = time.Now()
+		close(p.done)
+	}
+	p.lock.Unlock()
+	if !finished && p.logger != nil {
+		p.logger.Println(p.snapshot())
+	}
+}
+
+// snapshot returns the current progress, with throughput and estimated time remaining.
+func (p *progress) snapshot() progressReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := p.report
+	end := time.Now()
+	if result.Finished {
+		end = p.ended
+	}
+	elapsed := end.Sub(result.Started).Seconds()
+	result.ElapsedSeconds = elapsed
+	if elapsed <= 0 {
+		return result
+	}
+	result.BytesPerSecond = float64(result.DoneBytes) / elapsed
+	if result.Finished {
+		return result
+	}
+	if result.DoneBytes > 0 && result.TotalBytes > result.DoneBytes {
+		result.EtaSeconds = float64(result.TotalBytes-result.DoneBytes) / result.BytesPerSecond
+	} else if result.Done > 0 && result.Total > result.Done {
+		result.EtaSeconds = float64(result.Total-result.Done) * elapsed / float64(result.Done)
+	}
+	return result
+}
+
+func (r progressReport) String() string {
+	eta := "ETA unknown"
+	if r.Finished {
+		eta = fmt.Sprintf("finished in %v", time.Duration(r.ElapsedSeconds*float64(time.Second)))
+	} else if r.EtaSeconds > 0 {
+		eta = fmt.Sprintf("ETA %v", time.Duration(r.EtaSeconds)*time.Second)
+	}
+	phase := r.Name
+	if len(r.Phase) > 0 {
+		phase += " (" + r.Phase + ")"
+	}
+	return fmt.Sprintf("%s: %v/%v files, %v/%v MB, %.1f MB/s, %v", phase, r.Done, r.Total,
+		r.DoneBytes/megabyte, r.TotalBytes/megabyte, r.BytesPerSecond/megabyte, eta)
+}
+
+// progressBoard keeps the progress of running jobs, and of the last few finished jobs.
+// Progress of running jobs is logged periodically. All methods are safe to call on a nil progressBoard.
+type progressBoard struct {
+	interval time.Duration
+	logger   *log.Logger
+
+	lock sync.Mutex
+	all  []*progress
+}
+
+// newProgressBoard returns a progressBoard logging progress at the given interval, or never if it is zero.
+func newProgressBoard(interval time.Duration, logger *log.Logger) *progressBoard {
+	return &progressBoard{interval: interval, logger: logger}
+}
+
+// start begins tracking the progress of a new job.
+func (b *progressBoard) start(name string) *progress {
+	if b == nil {
+		return nil
+	}
+	p := &progress{
+		report: progressReport{Name: name, Started: time.Now()},
+		done:   make(chan struct{}),
+	}
+	if b.interval > 0 {
+		p.logger = b.logger
+	}
+	b.lock.Lock()
+	b.all = append(b.all, p)
+	b.prune()
+	b.lock.Unlock()
+	if b.interval > 0 {
+		go b.logUntilFinished(p)
+	}
+	return p
+}
+
+func (b *progressBoard) logUntilFinished(p *progress) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.logger.Println(p.snapshot())
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// prune removes the oldest finished progress past maxFinishedProgress, lock must be held.
+func (b *progressBoard) prune() {
+	finished := 0
+	for _, p := range b.all {
+		if p.snapshot().Finished {
+			finished++
+		}
+	}
+	var kept []*progress
+	for _, p := range b.all {
+		if finished > maxFinishedProgress && p.snapshot().Finished {
+			finished--
+			continue
+		}
+		kept = append(kept, p)
+	}
+	b.all = kept
+}
+
+// reports returns a snapshot of each tracked job, oldest first.
+func (b *progressBoard) reports() []progressReport {
+	result := []progressReport{}
+	if b == nil {
+		return result
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, p := range b.all {
+		result = append(result, p.snapshot())
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// songQuery is a set of field=value terms.
+// A song matches if every term value is found in the song field, ignoring case.
+type songQuery map[string]string
+
+// parseSongQuery parses comma separated field=value terms, ex. "album_artist=muse,date=2001"
+func parseSongQuery(query string) (songQuery, error) {
+	result := make(songQuery)
+	for _, term := range strings.Split(query, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("query term %q must be field=value", term)
+		}
+		field := strings.ToLower(strings.TrimSpace(kv[0]))
+		if _, ok := songField(&Song{}, field); !ok {
+			return nil, fmt.Errorf("unknown query field %q", field)
+		}
+		result[field] = strings.ToLower(strings.TrimSpace(kv[1]))
+	}
+	return result, nil
+}
+
+func (q songQuery) matches(song *Song) bool {
+	for field, value := range q {
+		songValue, _ := songField(song, field)
+		if !strings.Contains(strings.ToLower(songValue), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// songField returns the value of a song field by its json name, or false if there is no such field.
+func songField(song *Song, field string) (string, bool) {
+	switch field {
+	case "album":
+		return song.Album, true
+	case "artist":
+		return song.Artist, true
+	case "album_artist":
+		return song.AlbumArtist, true
+	case "composer":
+		return song.Composer, true
+	case "title":
+		return song.Title, true
+	case "comment":
+		return song.Comment, true
+	case "file_type":
+		return string(song.FileType), true
+	case "date":
+		return song.Date, true
+	case "path":
+		return song.Path, true
+	case "genre":
+		return song.Genre, true
+	case "original_date":
+		return song.OriginalDate, true
+	case "label":
+		return song.Label, true
+	case "catalog_number":
+		return song.CatalogNumber, true
+	case "isrc":
+		return song.ISRC, true
+	case "musicbrainz_album_id":
+		return song.MusicBrainzAlbumID, true
+	case "artist_sort":
+		return song.ArtistSort, true
+	case "album_artist_sort":
+		return song.AlbumArtistSort, true
+	case "track":
+		return strconv.Itoa(song.Track), true
+	case "disc":
+		return strconv.Itoa(song.Disc), true
+	case "disc_subtitle":
+		return song.DiscSubtitle, true
+	case "bpm":
+		return strconv.Itoa(song.BPM), true
+	case "compilation":
+		return strconv.FormatBool(song.Compilation), true
+	case "work":
+		return song.Work, true
+	case "movement_name":
+		return song.MovementName, true
+	case "movement":
+		return strconv.Itoa(song.Movement), true
+	case "conductor":
+		return song.Conductor, true
+	case "orchestra":
+		return song.Orchestra, true
+	case "performers":
+		return strings.Join(song.Performers, multiValueSeparator), true
+	}
+	return "", false
+}
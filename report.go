@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	albumsReport     = "albums"
+	artistsReport    = "artists"
+	healthReport     = "health"
+	duplicatesReport = "duplicates"
+)
+
+// reports printed by -report, each a check of the root library
+var reportNames = []string{albumsReport, artistsReport, healthReport, duplicatesReport}
+
+func checkReport(name string) error {
+	for _, report := range reportNames {
+		if name == report {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown report %q, must be one of %v", name, strings.Join(reportNames, ", "))
+}
+
+// writeReport writes the named report about the library as text.
+func writeReport(w io.Writer, name string, lib Library, args collectionArgs) error {
+	if err := checkReport(name); err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	switch name {
+	case albumsReport:
+		writeAlbumsReport(&buffer, lib)
+	case artistsReport:
+		writeArtistsReport(&buffer, lib, args.aliases)
+	case healthReport:
+		writeHealthReport(&buffer, lib, args)
+	case duplicatesReport:
+		writeDuplicatesReport(&buffer, lib, args.aliases)
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// writeAlbumsReport lists albums merged from songs that may belong to different albums, see ambiguousAlbums.
+func writeAlbumsReport(buffer *bytes.Buffer, lib Library) {
+	albums := ambiguousAlbums(lib)
+	for _, album := range albums {
+		fmt.Fprintf(buffer, "%q: %v\n", album.Name, strings.Join(album.Reasons, ", "))
+		for _, path := range album.Paths {
+			fmt.Fprintf(buffer, "  %v\n", path)
+		}
+	}
+	fmt.Fprintf(buffer, "%v ambiguous albums\n", len(albums))
+}
+
+// writeArtistsReport lists artist names that are probably the same artist, see findDuplicateArtists.
+// Those not merged already can be merged with artist aliases in the config file.
+func writeArtistsReport(buffer *bytes.Buffer, lib Library, aliases *artistAliases) {
+	duplicates := findDuplicateArtists(lib, aliases)
+	merged := 0
+	for _, duplicate := range duplicates {
+		var names []string
+		for i, name := range duplicate.Names {
+			names = append(names, fmt.Sprintf("%q (%v songs)", name, duplicate.Songs[i]))
+		}
+		status := "not merged"
+		if duplicate.Merged {
+			status = "merged"
+			merged++
+		}
+		fmt.Fprintf(buffer, "%v: %v, %v\n", strings.Join(names, ", "), duplicate.Reason, status)
+	}
+	fmt.Fprintf(buffer, "%v likely duplicate artists, %v merged\n", len(duplicates), merged)
+}
+
+// writeHealthReport lists albums with missing tracks or art, or with tags that differ across songs, see libraryHealth.
+func writeHealthReport(buffer *bytes.Buffer, lib Library, args collectionArgs) {
+	albums := libraryHealth(lib, args)
+	for _, album := range albums {
+		fmt.Fprintf(buffer, "%q by %q:\n", album.Name, album.Artist)
+		for _, problem := range album.Problems {
+			fmt.Fprintf(buffer, "  %v\n", problem.Problem)
+			for _, song := range problem.Songs {
+				fmt.Fprintf(buffer, "    %v %v\n", song.Hash, song.Path)
+			}
+		}
+	}
+	fmt.Fprintf(buffer, "%v albums with problems\n", len(albums))
+}
+
+// writeDuplicatesReport lists song files that are copies of the same recording, see findDuplicateSongs.
+func writeDuplicatesReport(buffer *bytes.Buffer, lib Library, aliases *artistAliases) {
+	duplicates := findDuplicateSongs(lib, aliases)
+	identical := 0
+	for _, duplicate := range duplicates {
+		fmt.Fprintf(buffer, "%v:\n", duplicate.Reason)
+		for _, song := range duplicate.Songs {
+			fmt.Fprintf(buffer, "  %v %.1fs %v\n", song.Hash, song.Duration, song.Path)
+		}
+		if duplicate.Songs[0].Hash == duplicate.Songs[1].Hash {
+			identical++
+		}
+	}
+	fmt.Fprintf(buffer, "%v duplicate songs, %v with identical audio\n", len(duplicates), identical)
+}
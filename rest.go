@@ -11,10 +11,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func buildServer(aad Collection, lib Library, gui bool) *http.Server {
+func buildServer(libs *libraryHolder, gui bool, mobile *mobileServer, tags *tagWriter, progress *progressBoard,
+	jobs *jobManager, schedules *scheduler) *http.Server {
 	router := httptreemux.NewContextMux()
 	router.PanicHandler = httptreemux.ShowErrorsPanicHandler
 	router.PathSource = httptreemux.URLPath
@@ -31,11 +35,29 @@ func buildServer(aad Collection, lib Library, gui bool) *http.Server {
 	}
 
 	restLog := log.New(os.Stdout, "[rest] ", log.LstdFlags|log.Lmicroseconds)
-	router.GET("/music/aad.json", aadHandler(aad, restLog))
-	router.GET("/music/metadata/:song", metaHandler(lib, restLog))
-	router.GET("/music/song/:song", songHandler(lib, restLog))
-	router.GET("/music/raw/:song", rawHandler(lib))
-	router.GET("/music/art/:art", artHandler(lib, restLog))
+	router.GET("/music/aad.json", aadHandler(libs, restLog))
+	router.GET("/music/classical.json", classicalHandler(libs, restLog))
+	router.GET("/music/metadata/:song", metaHandler(libs, restLog))
+	router.PATCH("/music/metadata/:song", overrideHandler(libs, jobs, restLog))
+	if tags != nil {
+		router.PUT("/music/tags/:song", writeTagsHandler(tags, jobs, restLog))
+	}
+	router.GET("/music/song/:song", songHandler(libs, restLog))
+	router.GET("/music/raw/:song", rawHandler(libs))
+	router.GET("/music/art/:art", artHandler(libs, restLog))
+	router.GET("/music/search", searchHandler(libs, restLog))
+	router.GET("/music/health", healthHandler(libs, restLog))
+	router.GET("/music/duplicates", duplicatesHandler(libs, restLog))
+	router.GET("/progress.json", progressHandler(progress))
+	router.GET("/jobs", jobsHandler(jobs))
+	router.POST("/jobs", startJobHandler(jobs, restLog))
+	router.GET("/jobs/:job", jobHandler(jobs, restLog))
+	router.DELETE("/jobs/:job", cancelJobHandler(jobs, restLog))
+	router.GET("/schedules", schedulesHandler(schedules))
+	if mobile != nil {
+		router.GET("/mobile/manifest.json", mobileManifestHandler(mobile, restLog))
+		router.GET("/mobile/file/*path", mobileFileHandler(mobile, restLog))
+	}
 
 	return &http.Server{
 		Handler:           router,
@@ -57,8 +79,9 @@ func writeYourErr(w http.ResponseWriter, logger *log.Logger, err error) {
 	http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 }
 
-func songHandler(lib Library, logger *log.Logger) http.HandlerFunc {
+func songHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		lib := libs.library()
 		if songArg, ok := httptreemux.ContextParams(req.Context())["song"]; ok {
 			if songHash, err := extractSongHash(songArg); err == nil {
 				if song := lib.findSong(songHash); song.File != "" {
@@ -118,8 +141,9 @@ func extractHash(file string) ([]byte, error) {
 	return base64.URLEncoding.DecodeString(asBase64 + "=")
 }
 
-func artHandler(lib Library, logger *log.Logger) http.HandlerFunc {
+func artHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		lib := libs.library()
 		if artArg, ok := httptreemux.ContextParams(req.Context())["art"]; ok {
 			if artHash, err := extractPicHash(artArg); err == nil {
 				if art := lib.findArt(artHash); art != nil {
@@ -137,8 +161,9 @@ func artHandler(lib Library, logger *log.Logger) http.HandlerFunc {
 	}
 }
 
-func metaHandler(lib Library, logger *log.Logger) http.HandlerFunc {
+func metaHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		lib := libs.library()
 		if songArg, ok := httptreemux.ContextParams(req.Context())["song"]; ok {
 			if songHash, err := extractSongHash(songArg); err == nil {
 				if song := lib.findSong(songHash); song.File != "" {
@@ -158,9 +183,123 @@ func metaHandler(lib Library, logger *log.Logger) http.HandlerFunc {
 	}
 }
 
+// Handler to override song metadata, ex. {"date": "1999", "album_artist": null}, where null removes an override.
+// The database is stored afterwards, if there is one.
+func overrideHandler(libs *libraryHolder, jobs *jobManager, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		songArg := httptreemux.ContextParams(req.Context())["song"]
+		hash, err := extractSongHash(songArg)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		if libs.library().findSong(hash) == nil {
+			writeNotFoundErr(writer, logger, fmt.Errorf("couldn't find song for hash: %v", songArg))
+			return
+		}
+		fields, err := readSongFields(req)
+		if err != nil {
+			writeYourErr(writer, logger, fmt.Errorf("invalid metadata override: %v", err))
+			return
+		}
+		song, err := libs.override(hash, fields)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		logger.Printf("overrode song metadata for %v: %v", songArg, song.Overrides)
+		if jobs.hasKind("store-database") {
+			if _, err := jobs.start("store-database"); err != nil {
+				logger.Println(err)
+			}
+		}
+		writeJson(writer, http.StatusOK, song)
+	}
+}
+
+// writeTagsHandler writes tag edits to the file of a song, see tagWriter.
+// The body is song fields by json name, ex. {"date": "1999"}, with null removing the tag.
+func writeTagsHandler(tags *tagWriter, jobs *jobManager, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		songArg := httptreemux.ContextParams(req.Context())["song"]
+		hash, err := extractSongHash(songArg)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		song := tags.libs.library().findSong(hash)
+		if song == nil {
+			writeNotFoundErr(writer, logger, fmt.Errorf("couldn't find song for hash: %v", songArg))
+			return
+		}
+		fields, err := readSongFields(req)
+		if err != nil {
+			writeYourErr(writer, logger, fmt.Errorf("invalid tag edit: %v", err))
+			return
+		}
+		if _, err := newTagEdits(song, fields); err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		if song.FileType != tag.FLAC && song.FileType != tag.MP3 {
+			writeYourErr(writer, logger, fmt.Errorf("tags of %v files can not be written, only FLAC and MP3", song.FileType))
+			return
+		}
+		song, err = tags.write(hash, fields)
+		if err != nil {
+			logger.Println(err)
+			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		var names []string
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		logger.Printf("wrote tags of %q: %v", song.Path, strings.Join(names, ", "))
+		if jobs.hasKind("store-database") {
+			if _, err := jobs.start("store-database"); err != nil {
+				logger.Println(err)
+			}
+		}
+		writeJson(writer, http.StatusOK, song)
+	}
+}
+
+// readSongFields reads a json object of song fields by json name, with null values as nil.
+// Numbers and booleans are read as text. Arrays and objects are not allowed, multiple values are separated by ;
+func readSongFields(req *http.Request) (map[string]*string, error) {
+	var body map[string]interface{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]*string, len(body))
+	for field, raw := range body {
+		var value string
+		switch raw := raw.(type) {
+		case nil:
+			fields[field] = nil
+			continue
+		case string:
+			value = raw
+		case json.Number:
+			value = raw.String()
+		case bool:
+			value = strconv.FormatBool(raw)
+		default:
+			return nil, fmt.Errorf("song field %q must be a string, number, boolean or null", field)
+		}
+		fields[field] = &value
+	}
+	return fields, nil
+}
+
 // Handler for artist-album-date collection
-func aadHandler(col Collection, logger *log.Logger) http.HandlerFunc {
+func aadHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		col := libs.collection()
 		logger.Println("serving aad collection, song_count:", col.SongCount())
 		buf := new(bytes.Buffer)
 		err := json.NewEncoder(buf).Encode(col)
@@ -171,9 +310,168 @@ func aadHandler(col Collection, logger *log.Logger) http.HandlerFunc {
 	}
 }
 
+// Handler for composer-work-recording collection of classical songs
+func classicalHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		col := libs.classicalCollection()
+		logger.Println("serving classical collection, song_count:", col.SongCount())
+		buf := new(bytes.Buffer)
+		err := json.NewEncoder(buf).Encode(col)
+		forbidErr(err)
+		writer.Header().Set(contentTypeHeader, jsonMime)
+		_, err = writer.Write(buf.Bytes())
+		forbidErr(err)
+	}
+}
+
+// Handler for the health of albums, see libraryHealth
+func healthHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		albums := libraryHealth(libs.library(), libs.args)
+		if albums == nil {
+			albums = []albumHealth{}
+		}
+		logger.Println("serving library health, albums with problems:", len(albums))
+		writeJson(writer, http.StatusOK, albums)
+	}
+}
+
+// Handler for duplicate song files, see findDuplicateSongs
+func duplicatesHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		duplicates := findDuplicateSongs(libs.library(), libs.args.aliases)
+		if duplicates == nil {
+			duplicates = []duplicateSongs{}
+		}
+		logger.Println("serving duplicate songs, count:", len(duplicates))
+		writeJson(writer, http.StatusOK, duplicates)
+	}
+}
+
+// Handler for search of songs, albums, and artists, ex. /music/search?q=origin%20symetry&limit=10
+func searchHandler(libs *libraryHolder, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query().Get("q")
+		limit := defaultSearchLimit
+		if limitArg := req.URL.Query().Get("limit"); len(limitArg) > 0 {
+			var err error
+			if limit, err = strconv.Atoi(limitArg); err != nil || limit < 1 {
+				writeYourErr(writer, logger, fmt.Errorf("invalid limit: %q", limitArg))
+				return
+			}
+		}
+		results := libs.searchIndex().search(query, limit)
+		logger.Printf("serving search for %q, %v artists, %v albums, %v songs",
+			query, len(results.Artists), len(results.Albums), len(results.Songs))
+		writeJson(writer, http.StatusOK, results)
+	}
+}
+
+// Handler for progress of running and recently finished jobs
+func progressHandler(progress *progressBoard) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		buf := new(bytes.Buffer)
+		err := json.NewEncoder(buf).Encode(progress.reports())
+		forbidErr(err)
+		writer.Header().Set(contentTypeHeader, jsonMime)
+		_, err = writer.Write(buf.Bytes())
+		forbidErr(err)
+	}
+}
+
+func writeJson(writer http.ResponseWriter, status int, value interface{}) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(value)
+	forbidErr(err)
+	writer.Header().Set(contentTypeHeader, jsonMime)
+	writer.WriteHeader(status)
+	_, err = writer.Write(buf.Bytes())
+	forbidErr(err)
+}
+
+// Handler for the list of queued, running, and recently finished jobs
+func jobsHandler(jobs *jobManager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writeJson(writer, http.StatusOK, jobs.list())
+	}
+}
+
+// Handler for scheduled jobs, with when they run next and the last job they started
+func schedulesHandler(schedules *scheduler) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writeJson(writer, http.StatusOK, schedules.reports())
+	}
+}
+
+// jobRequest is the body of a request to start a job, ex. {"kind": "scan"}
+type jobRequest struct {
+	Kind string `json:"kind"`
+}
+
+// Handler to queue a new job
+func startJobHandler(jobs *jobManager, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		var body jobRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeYourErr(writer, logger, fmt.Errorf("invalid job request: %v", err))
+			return
+		}
+		report, err := jobs.start(body.Kind)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		logger.Printf("queued job %v, kind: %v", report.ID, report.Kind)
+		writeJson(writer, http.StatusAccepted, report)
+	}
+}
+
+func extractJobId(req *http.Request) (int, error) {
+	jobArg := httptreemux.ContextParams(req.Context())["job"]
+	id, err := strconv.Atoi(jobArg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid job id: %q", jobArg)
+	}
+	return id, nil
+}
+
+// Handler for a single job, with its log
+func jobHandler(jobs *jobManager, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		id, err := extractJobId(req)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		if report, ok := jobs.get(id); ok {
+			writeJson(writer, http.StatusOK, report)
+		} else {
+			writeNotFoundErr(writer, logger, fmt.Errorf("unknown job: %v", id))
+		}
+	}
+}
+
+// Handler to cancel a job
+func cancelJobHandler(jobs *jobManager, logger *log.Logger) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		id, err := extractJobId(req)
+		if err != nil {
+			writeYourErr(writer, logger, err)
+			return
+		}
+		if report, ok := jobs.cancel(id); ok {
+			logger.Printf("canceled job %v, kind: %v", report.ID, report.Kind)
+			writeJson(writer, http.StatusOK, report)
+		} else {
+			writeNotFoundErr(writer, logger, fmt.Errorf("unknown job: %v", id))
+		}
+	}
+}
+
 // TODO: Add secret toggle in gui to expose this data for use while debugging tag package
-func rawHandler(lib Library) http.HandlerFunc {
+func rawHandler(libs *libraryHolder) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		lib := libs.library()
 		writer.Header().Set(contentTypeHeader, jsonMime)
 		if songArg, ok := httptreemux.ContextParams(req.Context())["song"]; ok {
 			if songHash, err := extractSongHash(songArg); err == nil {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// scheduleReport is the state of a scheduled job, with the last job it started.
+type scheduleReport struct {
+	Cron    string     `json:"cron"`
+	Job     string     `json:"job"`
+	Next    time.Time  `json:"next"`
+	LastRun time.Time  `json:"last_run"`
+	LastJob *jobReport `json:"last_job,omitempty"` // nil if never run, or if the job is no longer kept
+	Error   string     `json:"error,omitempty"`    // why the last run did not start a job, if it did not
+}
+
+type schedule struct {
+	cron *cronSchedule
+	job  string
+
+	lock    sync.Mutex
+	next    time.Time
+	lastRun time.Time
+	lastJob int // id of the last job started, or zero
+	lastErr string
+}
+
+// scheduler starts jobs whenever their cron expression matches.
+// A job is not started again while the job it started last time is still queued or running.
+// All methods are safe to call on a nil scheduler.
+type scheduler struct {
+	jobs      *jobManager
+	logger    *log.Logger
+	schedules []*schedule
+}
+
+// newScheduler checks that each schedule has a valid cron expression and a known kind of job.
+func newScheduler(jobs *jobManager, configs []scheduleConfig, logger *log.Logger) (*scheduler, error) {
+	result := &scheduler{jobs: jobs, logger: logger}
+	for _, sc := range configs {
+		cron, err := parseCron(sc.Cron)
+		if err != nil {
+			return nil, err
+		}
+		if !jobs.hasKind(sc.Job) {
+			return nil, fmt.Errorf("unknown scheduled job kind %q, expected one of %v", sc.Job, jobs.kindNames())
+		}
+		result.schedules = append(result.schedules, &schedule{cron: cron, job: sc.Job})
+	}
+	return result, nil
+}
+
+// start runs each schedule in the background.
+func (s *scheduler) start() {
+	if s == nil {
+		return
+	}
+	for _, sch := range s.schedules {
+		go s.run(sch)
+	}
+}
+
+func (s *scheduler) run(sch *schedule) {
+	for {
+		next := sch.cron.next(time.Now())
+		if next.IsZero() {
+			s.logger.Printf("%q never matches, %v will not be scheduled", sch.cron, sch.job)
+			return
+		}
+		sch.lock.Lock()
+		sch.next = next
+		sch.lock.Unlock()
+		s.logger.Printf("next %v at %v", sch.job, next)
+		time.Sleep(time.Until(next))
+
+		sch.lock.Lock()
+		sch.lastRun = time.Now()
+		last, ok := s.jobs.get(sch.lastJob)
+		if ok && (last.State == jobQueued || last.State == jobRunning) {
+			sch.lastErr = fmt.Sprintf("skipped, job %v is still %v", last.ID, last.State)
+			s.logger.Printf("%v %s", sch.job, sch.lastErr)
+		} else if report, err := s.jobs.start(sch.job); err == nil {
+			sch.lastJob, sch.lastErr = report.ID, ""
+			s.logger.Printf("started %v job %v", sch.job, report.ID)
+		} else {
+			sch.lastErr = err.Error()
+			s.logger.Printf("failed to start %v: %v", sch.job, err)
+		}
+		sch.lock.Unlock()
+	}
+}
+
+// reports returns the state of each schedule, in config order.
+func (s *scheduler) reports() []scheduleReport {
+	result := []scheduleReport{}
+	if s == nil {
+		return result
+	}
+	for _, sch := range s.schedules {
+		sch.lock.Lock()
+		report := scheduleReport{
+			Cron:    sch.cron.String(),
+			Job:     sch.job,
+			Next:    sch.next,
+			LastRun: sch.lastRun,
+			Error:   sch.lastErr,
+		}
+		if last, ok := s.jobs.get(sch.lastJob); ok {
+			last.Log = nil
+			report.LastJob = &last
+		}
+		sch.lock.Unlock()
+		result = append(result, report)
+	}
+	return result
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// default max results of each kind returned by a search
+	defaultSearchLimit = 20
+	// score of a query term matching the start of an indexed word
+	prefixMatchScore = 0.8
+	// score of a query term matching an indexed word with one or two typos
+	typoMatchScore = 0.5
+	// query terms shorter than this only match whole words or prefixes, not typos
+	minTypoLength = 4
+	// query terms at least this long may have two typos
+	minTwoTypoLength = 8
+)
+
+// weights of each song field when scoring search results
+var searchFieldWeights = map[string]float64{
+	"title":        1.0,
+	"artist":       0.8,
+	"album_artist": 0.7,
+	"album":        0.6,
+	"composer":     0.5,
+}
+
+// letters that do not decompose into a base letter and diacritics
+var foldReplacer = strings.NewReplacer("ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "þ", "th", "ı", "i")
+
+// foldText lowercases text and removes diacritics, so that "Beyoncé" and "beyonce" are the same.
+func foldText(text string) string {
+	fold := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(fold, strings.ToLower(text))
+	if err != nil {
+		folded = strings.ToLower(text)
+	}
+	return foldReplacer.Replace(folded)
+}
+
+// searchTerms splits text into folded words.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(foldText(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchDoc is a song, album, or artist that can be found by searching.
+type searchDoc struct {
+	words map[string]float64 // best field weight of each word
+	songs []*Song
+	name  string
+}
+
+func (d *searchDoc) add(field, text string) {
+	weight := searchFieldWeights[field]
+	for _, word := range searchTerms(text) {
+		if weight > d.words[word] {
+			d.words[word] = weight
+		}
+	}
+}
+
+// searchIndex is an inverted index of songs, albums, and artists, from each word to the docs containing it.
+type searchIndex struct {
+	docs     []*searchDoc
+	kinds    []string         // song, album, or artist for each doc
+	postings map[string][]int // word to indexes of docs
+	words    []string         // sorted, for prefix matches
+}
+
+type searchArtist struct {
+	Name      string  `json:"name"`
+	SongCount int     `json:"song_count"`
+	Score     float64 `json:"score"`
+}
+
+type searchAlbum struct {
+	Name      string   `json:"name"`
+	Artist    string   `json:"artist"`
+	Date      string   `json:"date,omitempty"`
+	FirstSong string   `json:"first_song"`
+	SongFiles []string `json:"song_files"`
+	Score     float64  `json:"score"`
+}
+
+type searchSong struct {
+	*Song
+	Score float64 `json:"score"`
+}
+
+// searchResults are the ranked results of a search, best first.
+type searchResults struct {
+	Query   string         `json:"query"`
+	Artists []searchArtist `json:"artists"`
+	Albums  []searchAlbum  `json:"albums"`
+	Songs   []searchSong   `json:"songs"`
+}
+
+// newSearchIndex indexes the title, artist, album artist, album and composer of each song.
+// Albums are indexed by album and album artist, and artists by artist and album artist, after renaming by any alias.
+func newSearchIndex(lib Library, aliases *artistAliases, logger *log.Logger) *searchIndex {
+	start := time.Now()
+	result := &searchIndex{postings: make(map[string][]int)}
+	albums := make(map[string]*searchDoc)
+	artists := make(map[string]*searchDoc)
+	var albumKeys, artistKeys []string
+	forbidErr(lib.songs(func(song *Song) error {
+		doc := &searchDoc{words: make(map[string]float64), songs: []*Song{song}, name: song.Title}
+		doc.add("title", song.Title)
+		doc.add("artist", song.Artist)
+		doc.add("album_artist", song.AlbumArtist)
+		doc.add("album", song.Album)
+		doc.add("composer", song.Composer)
+		result.addDoc("song", doc)
+
+		if len(song.Album) > 0 {
+			key := songAlbumKey(song)
+			album, ok := albums[key]
+			if !ok {
+				album = &searchDoc{words: make(map[string]float64), name: song.Album}
+				album.add("album", song.Album)
+				albums[key] = album
+				albumKeys = append(albumKeys, key)
+			}
+			for _, artist := range songAlbumArtists(song) {
+				album.add("album_artist", artist)
+				album.add("album_artist", aliases.canonical(artist))
+			}
+			album.songs = append(album.songs, song)
+		}
+
+		for _, name := range songArtists(song) {
+			name = aliases.canonical(name)
+			key := artistKey(name)
+			artist, ok := artists[key]
+			if !ok {
+				artist = &searchDoc{words: make(map[string]float64), name: name}
+				artist.add("artist", name)
+				artists[key] = artist
+				artistKeys = append(artistKeys, key)
+			}
+			if len(artist.songs) == 0 || artist.songs[len(artist.songs)-1] != song {
+				artist.songs = append(artist.songs, song)
+			}
+		}
+		return nil
+	}))
+	sort.Strings(albumKeys)
+	for _, key := range albumKeys {
+		result.addDoc("album", albums[key])
+	}
+	sort.Strings(artistKeys)
+	for _, key := range artistKeys {
+		result.addDoc("artist", artists[key])
+	}
+	for word := range result.postings {
+		result.words = append(result.words, word)
+	}
+	sort.Strings(result.words)
+	logger.Printf("indexed %v words of %v songs, %v albums and %v artists for search in %v",
+		len(result.words), lib.songCount(), len(albums), len(artists), time.Now().Sub(start))
+	return result
+}
+
+func (idx *searchIndex) addDoc(kind string, doc *searchDoc) {
+	id := len(idx.docs)
+	idx.docs = append(idx.docs, doc)
+	idx.kinds = append(idx.kinds, kind)
+	for word := range doc.words {
+		idx.postings[word] = append(idx.postings[word], id)
+	}
+}
+
+// matchWords returns the score of each indexed word matching a query term, by whole word, prefix, or typos.
+func (idx *searchIndex) matchWords(term string) map[string]float64 {
+	result := make(map[string]float64)
+	for i := sort.SearchStrings(idx.words, term); i < len(idx.words) && strings.HasPrefix(idx.words[i], term); i++ {
+		if idx.words[i] == term {
+			result[term] = 1
+		} else {
+			result[idx.words[i]] = prefixMatchScore
+		}
+	}
+	termLength := len([]rune(term))
+	if termLength < minTypoLength {
+		return result
+	}
+	maxTypos := 1
+	if termLength >= minTwoTypoLength {
+		maxTypos = 2
+	}
+	for _, word := range idx.words {
+		if _, ok := result[word]; ok {
+			continue
+		}
+		if typos := editDistance(term, word, maxTypos); typos <= maxTypos {
+			result[word] = typoMatchScore
+		}
+	}
+	return result
+}
+
+// search returns the songs, albums, and artists matching every term of the query, best first.
+func (idx *searchIndex) search(query string, limit int) searchResults {
+	results := searchResults{Query: query, Artists: []searchArtist{}, Albums: []searchAlbum{}, Songs: []searchSong{}}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return results
+	}
+	var scores map[int]float64
+	for _, term := range terms {
+		termScores := make(map[int]float64)
+		for word, wordScore := range idx.matchWords(term) {
+			for _, id := range idx.postings[word] {
+				if score := wordScore * idx.docs[id].words[word]; score > termScores[id] {
+					termScores[id] = score
+				}
+			}
+		}
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id, score := range scores {
+			if termScore, ok := termScores[id]; ok {
+				scores[id] = score + termScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	var ids []int
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return idx.docs[ids[i]].name < idx.docs[ids[j]].name
+	})
+	for _, id := range ids {
+		doc, score := idx.docs[id], scores[id]/float64(len(terms))
+		switch idx.kinds[id] {
+		case "song":
+			if len(results.Songs) < limit {
+				results.Songs = append(results.Songs, searchSong{Song: doc.songs[0], Score: score})
+			}
+		case "album":
+			if len(results.Albums) < limit {
+				results.Albums = append(results.Albums, newSearchAlbum(doc, score))
+			}
+		case "artist":
+			if len(results.Artists) < limit {
+				results.Artists = append(results.Artists, searchArtist{Name: doc.name, SongCount: len(doc.songs), Score: score})
+			}
+		}
+	}
+	return results
+}
+
+func newSearchAlbum(doc *searchDoc, score float64) searchAlbum {
+	songs := append([]*Song{}, doc.songs...)
+	sort.Slice(songs, compareSongTrack(songs))
+	result := searchAlbum{
+		Name:      doc.name,
+		Artist:    songs[0].AlbumArtist,
+		Date:      songs[0].Date,
+		FirstSong: songs[0].File,
+		Score:     score,
+	}
+	if len(result.Artist) == 0 {
+		result.Artist = songs[0].Artist
+	}
+	for _, song := range songs {
+		result.SongFiles = append(result.SongFiles, song.MetaFile)
+	}
+	return result
+}
+
+// editDistance returns the Damerau-Levenshtein (optimal string alignment) distance between a and b,
+// or max+1 if it is more than max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return minInt(prev[len(rb)], max+1)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
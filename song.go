@@ -36,23 +36,71 @@ type Song struct {
 	FileType    tag.FileType `json:"file_type,omitempty"`    // ex. flac, mp3, m4a, ogg
 	Date        string       `json:"date,omitempty"`         // one hopes this is ISO-8601, used to sort albums
 
-	Path       string     `json:"-"` // filesystem path
-	Hash       songHash   `json:"-"` // metadata agnostic audio hash
-	MetaFormat tag.Format `json:"-"` // ex. vorbis, id3, mp4
+	Genre         string `json:"genre,omitempty"`          // ex. Alternative Rock
+	TotalTracks   int    `json:"total_tracks,omitempty"`   // tracks on this disc, or on the album
+	TotalDiscs    int    `json:"total_discs,omitempty"`    // ex. 2
+	DiscSubtitle  string `json:"disc_subtitle,omitempty"`  // ex. The Live Set
+	OriginalDate  string `json:"original_date,omitempty"`  // first release date, for reissues and remasters
+	Label         string `json:"label,omitempty"`          // ex. Mushroom
+	CatalogNumber string `json:"catalog_number,omitempty"` // ex. MUSH93CD
+	ISRC          string `json:"isrc,omitempty"`           // international standard recording code
+	BPM           int    `json:"bpm,omitempty"`            // beats per minute
+	Compilation   bool   `json:"compilation,omitempty"`    // flagged as part of a compilation, ex. Now 10
+
+	Duration   float64     `json:"duration,omitempty"`    // seconds, ex. 211.88, 0 if unknown, see songDuration
+	ReplayGain *ReplayGain `json:"replay_gain,omitempty"` // loudness normalization, see newReplayGain
+
+	MusicBrainzTrackID        string `json:"musicbrainz_track_id,omitempty"`         // recording id
+	MusicBrainzAlbumID        string `json:"musicbrainz_album_id,omitempty"`         // release id
+	MusicBrainzArtistID       string `json:"musicbrainz_artist_id,omitempty"`        // ex. 9c9f1380-2516-4fc9-a3e6-f9f61941d090
+	MusicBrainzAlbumArtistID  string `json:"musicbrainz_album_artist_id,omitempty"`  // ex. 9c9f1380-2516-4fc9-a3e6-f9f61941d090
+	MusicBrainzReleaseGroupID string `json:"musicbrainz_release_group_id,omitempty"` // shared by all releases of an album
+
+	Artists      []string `json:"artists,omitempty"`       // each artist, ex. [Jay-Z, Beyoncé]
+	AlbumArtists []string `json:"album_artists,omitempty"` // each album artist, the song is listed under each one
+	Composers    []string `json:"composers,omitempty"`     // each composer
+	Genres       []string `json:"genres,omitempty"`        // ex. [Alternative Rock, Progressive Rock]
+
+	Work         string   `json:"work,omitempty"`          // classical work, ex. Symphony No. 5 in C minor, Op. 67
+	MovementName string   `json:"movement_name,omitempty"` // ex. Allegro con brio
+	Movement     int      `json:"movement,omitempty"`      // movement number within the work
+	Conductor    string   `json:"conductor,omitempty"`     // ex. Carlos Kleiber
+	Orchestra    string   `json:"orchestra,omitempty"`     // orchestra or ensemble, ex. Wiener Philharmoniker
+	Performers   []string `json:"performers,omitempty"`    // ex. [Martha Argerich (piano)]
+
+	ParsedDate         *partialDate `json:"parsed_date,omitempty"`          // Date to its precision, ex. 2003-05
+	ParsedOriginalDate *partialDate `json:"parsed_original_date,omitempty"` // OriginalDate to its precision
+
+	ArtistSort      string `json:"artist_sort,omitempty"`       // ex. Beatles, The
+	AlbumArtistSort string `json:"album_artist_sort,omitempty"` // ex. Beatles, The
+
+	Overrides map[string]string `json:"overrides,omitempty"` // fields shown instead of the tagged ones, see metadataOverride
+	Tagged    map[string]string `json:"tagged,omitempty"`    // tagged values of overridden fields
+	// tagged values of overridden multi-valued fields, which may not be the Tagged value split by ;
+	TaggedValues map[string][]string `json:"-"`
+
+	Path           string     `json:"-"` // filesystem path
+	DuplicatePaths []string   `json:"-"` // other paths with the same audio, see library.putSongAndArt
+	Hash           songHash   `json:"-"` // metadata agnostic audio hash
+	MetaFormat     tag.Format `json:"-"` // ex. vorbis, id3, mp4
 }
 
 const OPUS tag.FileType = "OPUS"
 
-// Everything we care about from metadata is copied, except artwork
-func (s *Song) copyMetadata(meta tag.Metadata) {
+// Everything we care about from metadata is copied, except artwork.
+// Artist, album artist, composer and genre tags are split into multiple values by the delimiters.
+func (s *Song) copyMetadata(meta tag.Metadata, delimiters []string) {
 	s.Album = meta.Album()
 	s.Artist = meta.Artist()
 	s.AlbumArtist = meta.AlbumArtist()
 	s.Composer = meta.Composer()
 	s.Title = meta.Title()
-	s.Track, _ = meta.Track()
-	s.Disc, _ = meta.Disc()
+	s.Track, s.TotalTracks = meta.Track()
+	s.Disc, s.TotalDiscs = meta.Disc()
+	s.Genre = meta.Genre()
 	s.MetaFormat = meta.Format()
+	s.copyExtendedMetadata(meta)
+	s.copyMultiValueMetadata(meta, delimiters)
 
 	// FileType... work around m4a detection bug
 	s.FileType = meta.FileType()
@@ -69,4 +117,5 @@ func (s *Song) copyMetadata(meta tag.Metadata) {
 	} else if meta.Year() > 0 {
 		s.Date = strconv.Itoa(meta.Year())
 	}
+	s.parseDates()
 }
@@ -39,14 +39,14 @@ new Vue({
             if (this.breadcrumb.length === 1) {
                 let discography = this.collection[idx];
                 for (let album of discography.children) {
-                    for (let metaFile of album.song_files) {
+                    for (let metaFile of this.albumSongFiles(album)) {
                         freshQueue.push({meta_file: metaFile, title: "..."});
                     }
                 }
                 freshQueue[0].file = discography.first_song;
             } else if (this.breadcrumb.length === 2) {
                 let album = this.collection[this.breadcrumb[1].idx].children[idx];
-                for (let metaFile of album.song_files) {
+                for (let metaFile of this.albumSongFiles(album)) {
                     freshQueue.push({meta_file: metaFile, title: "..."});
                 }
                 freshQueue[0].file = album.first_song;
@@ -58,6 +58,16 @@ new Vue({
             this.loadedPlaylist = true;
             this.updatePlayQueue();
         },
+        albumSongFiles: function (album) { // multi-disc albums have their songs in a child for each disc
+            if (!album.children) {
+                return album.song_files;
+            }
+            let metaFiles = [];
+            for (let disc of album.children) {
+                metaFiles = metaFiles.concat(disc.song_files);
+            }
+            return metaFiles;
+        },
         browseUp: function (idx) {
             this.breadcrumb = this.breadcrumb.slice(0, idx+1);
             if (idx === 0) { // entire collection
@@ -77,7 +87,7 @@ new Vue({
                 this.breadcrumb.push({name: album.name, idx: idx});
 
                 // song placeholders
-                let metaFiles = album.song_files;
+                let metaFiles = this.albumSongFiles(album);
                 let songs = [];
                 for (let i = 0; i < metaFiles.length; i++) {
                     songs.push({meta_file: metaFiles[i], title: "..."});
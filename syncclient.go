@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// command line argument to run a sync client instead of the server
+	syncClientCommand = "sync-client"
+	// file in the mobile library root recording the manifest hash of each synced file
+	syncStateFile = ".discographic-sync.json"
+	// folder in the mobile library root for partially downloaded files
+	partFolder = ".discographic-partial"
+	// downloaded files between saves of the sync state
+	syncStateInterval = 50
+	// suffix of the file next to a partial download that records the ETag of the partial content
+	etagSuffix = ".etag"
+)
+
+// runSyncClient syncs a local mobile library from a server running with -serve-mobile.
+// Files that are not in the server manifest are deleted, only files that changed are downloaded,
+// and partial downloads are resumed.
+func runSyncClient(arguments []string) error {
+	var (
+		server string
+		mobile string
+	)
+	flags := flag.NewFlagSet(syncClientCommand, flag.ExitOnError)
+	flags.StringVar(&server, "server", "", "address of server running with -serve-mobile, ex. http://nas:61337")
+	flags.StringVar(&mobile, "mobile", "", "mobile music library folder")
+	if err := flags.Parse(arguments); err != nil {
+		return err
+	}
+	if len(server) == 0 || len(mobile) == 0 {
+		return fmt.Errorf("must provide -server and -mobile arguments")
+	}
+	server = strings.TrimSuffix(server, "/")
+	mobile = ensurePathSep(mobile)
+
+	client := &http.Client{}
+	manifest, err := fetchManifest(client, server)
+	if err != nil {
+		return err
+	}
+	log.Printf("mobile manifest from %v has %v files", server, len(manifest.Files))
+	if err := checkManifestFiles(manifest.Files); err != nil {
+		return err
+	}
+	if err := checkMobileFs(manifest.FS); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(mobile, os.ModePerm); err != nil {
+		return err
+	}
+
+	wanted := make(map[string]struct{})
+	parts := make(map[string]struct{})
+	for _, file := range manifest.Files {
+		wanted[mobilePathKey(manifest.FS, filepath.FromSlash(file.Path))] = struct{}{}
+		parts[partPath(file)] = struct{}{}
+		parts[partPath(file)+etagSuffix] = struct{}{}
+	}
+
+	// delete unknown, keeping partial downloads that can be resumed
+	log.Println("deleting unknown files")
+	allMobile, err := runPathWalkers(mobile)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]struct{})
+	for path := range allMobile {
+		mobilePath := path[len(mobile):]
+		key := mobilePathKey(manifest.FS, mobilePath)
+		if _, ok := wanted[key]; ok {
+			existing[key] = struct{}{}
+			continue
+		}
+		if _, ok := parts[mobilePath]; ok || mobilePath == syncStateFile {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			log.Printf("deleted: %q\n", path)
+		} else {
+			log.Println(err)
+		}
+	}
+	if err := removeEmptyFolders(mobile, log.Default()); err != nil {
+		return err
+	}
+
+	// download anything new or changed
+	state := readSyncState(mobile)
+	downloaded := 0
+	for _, file := range manifest.Files {
+		path := filepath.FromSlash(file.Path)
+		if _, ok := existing[mobilePathKey(manifest.FS, path)]; ok && state[file.Path] == file.Hash {
+			log.Println("Already exists:", mobile+path)
+			continue
+		}
+		if err := downloadMobileFile(client, server, mobile, file); err != nil {
+			_ = writeSyncState(mobile, state)
+			return err
+		}
+		state[file.Path] = file.Hash
+		downloaded++
+		if downloaded%syncStateInterval == 0 {
+			if err := writeSyncState(mobile, state); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("downloaded %v of %v files", downloaded, len(manifest.Files))
+
+	// forget files that are no longer wanted
+	for path := range state {
+		if _, ok := wanted[mobilePathKey(manifest.FS, filepath.FromSlash(path))]; !ok {
+			delete(state, path)
+		}
+	}
+	return writeSyncState(mobile, state)
+}
+
+func fetchManifest(client *http.Client, server string) (mobileManifest, error) {
+	var result mobileManifest
+	resp, err := client.Get(server + "/mobile/manifest.json")
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("failed to get mobile manifest from %v: %v", server, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
+
+// checkManifestFiles cleans the paths of manifest files, and returns an error if a path is absolute, is outside
+// the mobile library or is a file the sync client keeps in it, or if a hash is not hexadecimal,
+// so that a manifest can not write anywhere else.
+func checkManifestFiles(files []mobileManifestFile) error {
+	for i, file := range files {
+		if _, err := hex.DecodeString(file.Hash); err != nil || len(file.Hash) == 0 {
+			return fmt.Errorf("mobile manifest file %q has invalid hash %q", file.Path, file.Hash)
+		}
+		path := filepath.Clean(filepath.FromSlash(file.Path))
+		first := strings.SplitN(path, string(filepath.Separator), 2)[0]
+		if strings.HasPrefix(file.Path, "/") || filepath.IsAbs(path) || len(filepath.VolumeName(path)) > 0 ||
+			first == "." || first == ".." || first == partFolder || path == syncStateFile {
+			return fmt.Errorf("mobile manifest file %q is not in the mobile library", file.Path)
+		}
+		files[i].Path = filepath.ToSlash(path)
+	}
+	return nil
+}
+
+// partPath returns the path relative to the mobile library a file is downloaded to before it is complete.
+// It is named by the file hash, so a partial download is only resumed if the file has not changed since.
+func partPath(file mobileManifestFile) string {
+	return filepath.Join(partFolder, file.Hash)
+}
+
+// downloadMobileFile downloads a file from the server, resuming any partial download.
+// The download is checked against the content hash the server sends as the ETag, and if it does not match,
+// the file is downloaded again from the start.
+func downloadMobileFile(client *http.Client, server string, mobile string, file mobileManifestFile) error {
+	outPath := mobile + filepath.FromSlash(file.Path)
+	outPartPath := mobile + partPath(file)
+	if err := ensureFolders(outPartPath); err != nil {
+		return err
+	}
+	for restarted := false; ; restarted = true {
+		ok, err := downloadPart(client, server, outPartPath, file)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if restarted {
+			return fmt.Errorf("download of %q does not match its hash", file.Path)
+		}
+		log.Printf("download of %q does not match its hash, downloading again", outPath)
+		if err := os.Remove(outPartPath); err != nil {
+			return err
+		}
+		_ = os.Remove(outPartPath + etagSuffix)
+	}
+	if err := ensureFolders(outPath); err != nil {
+		return err
+	}
+	if err := os.Rename(outPartPath, outPath); err != nil {
+		return err
+	}
+	_ = os.Remove(outPartPath + etagSuffix)
+	return nil
+}
+
+// downloadPart downloads a file from the server to partPath, resuming from the end of any partial download
+// if the server content still has the ETag recorded for it. Returns true if the downloaded file matches
+// the content hash in the ETag, or if the server sent no ETag.
+func downloadPart(client *http.Client, server string, partPath string, file mobileManifestFile) (bool, error) {
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = part.Close()
+	}()
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	etag := ""
+	if data, err := ioutil.ReadFile(partPath + etagSuffix); err == nil {
+		etag = string(data)
+	}
+
+	var escaped []string
+	for _, segment := range strings.Split(file.Path, "/") {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	req, err := http.NewRequest("GET", server+"/mobile/file/"+strings.Join(escaped, "/"), nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 && len(etag) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		log.Printf("resuming download of %q at %v bytes", file.Path, offset)
+	case http.StatusOK:
+		if err := part.Truncate(0); err != nil {
+			return false, err
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// probably already fully downloaded, checked below
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+	default:
+		return false, fmt.Errorf("failed to download %q: %v", file.Path, resp.Status)
+	}
+	if respEtag := resp.Header.Get("ETag"); len(respEtag) > 0 {
+		etag = respEtag
+	}
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		if len(etag) > 0 {
+			if err := ioutil.WriteFile(partPath+etagSuffix, []byte(etag), 0644); err != nil {
+				return false, err
+			}
+		}
+		count, err := io.Copy(part, resp.Body)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("Downloaded file (size %v) of %q", count, file.Path)
+	}
+	if err := part.Sync(); err != nil {
+		return false, err
+	}
+	if len(etag) == 0 {
+		return true, nil
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	hash, err := contentHash(part)
+	if err != nil {
+		return false, err
+	}
+	return `"`+hash+`"` == etag, nil
+}
+
+func readSyncState(mobile string) map[string]string {
+	result := make(map[string]string)
+	data, err := ioutil.ReadFile(mobile + syncStateFile)
+	if err != nil {
+		return result
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Println("ignoring invalid sync state file", err)
+		return make(map[string]string)
+	}
+	for path, hash := range result {
+		// hashes were unpadded URL safe base64 before they were hexadecimal, see versionOf
+		if decoded, err := base64.RawURLEncoding.DecodeString(hash); err == nil && len(decoded) == sha1.Size {
+			result[path] = hex.EncodeToString(decoded)
+		}
+	}
+	return result
+}
+
+func writeSyncState(mobile string, state map[string]string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mobile+syncStateFile, data, 0644)
+}
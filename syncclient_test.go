@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckManifestFiles(t *testing.T) {
+	hash := versionOf("song")
+	tests := []struct {
+		path, hash string
+		want       string // cleaned path, "" if rejected
+	}{
+		{path: "Muse/Absolution/01 Intro.opus", hash: hash, want: "Muse/Absolution/01 Intro.opus"},
+		{path: "Muse//Absolution/./cover.jpg", hash: hash, want: "Muse/Absolution/cover.jpg"},
+		{path: "Muse/../cover.jpg", hash: hash, want: "cover.jpg"},
+		{path: "Muse/01.opus", hash: "ABCDEF0123", want: "Muse/01.opus"},
+		{path: "../../.bashrc", hash: hash},
+		{path: "Muse/../../.bashrc", hash: hash},
+		{path: "/etc/passwd", hash: hash},
+		{path: "..", hash: hash},
+		{path: ".", hash: hash},
+		{path: "", hash: hash},
+		{path: partFolder + "/x", hash: hash},
+		{path: syncStateFile, hash: hash},
+		{path: "Muse/01.opus", hash: "../../.bashrc"},
+		{path: "Muse/01.opus", hash: "abc"},
+		{path: "Muse/01.opus", hash: ""},
+	}
+	for _, test := range tests {
+		files := []mobileManifestFile{{Path: test.path, Hash: test.hash}}
+		err := checkManifestFiles(files)
+		if (err == nil) != (len(test.want) > 0) {
+			t.Errorf("checkManifestFiles(%q, %q) error = %v", test.path, test.hash, err)
+		} else if err == nil && files[0].Path != test.want {
+			t.Errorf("checkManifestFiles(%q) cleaned to %q, want %q", test.path, files[0].Path, test.want)
+		}
+	}
+}
+
+func TestReadSyncStateHexHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mobile := ensurePathSep(dir)
+	state := `{"a.opus": "3q2-7wAAAAAAAAAAAAAAAAAAAAA", "b.opus": "` + versionOf("b") + `"}`
+	if err := ioutil.WriteFile(filepath.Join(dir, syncStateFile), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := readSyncState(mobile)
+	if want := "deadbeef00000000000000000000000000000000"; got["a.opus"] != want {
+		t.Errorf("got hash %q of base64 hash, want %q", got["a.opus"], want)
+	}
+	if got["b.opus"] != versionOf("b") {
+		t.Errorf("got hash %q, want %q", got["b.opus"], versionOf("b"))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"strconv"
+	"strings"
+)
+
+// Raw tag names of extended song fields, lowercase, in order of preference.
+// Vorbis comments are named as is, ID3 frames by frame id, or txxx:description and ufid:owner,
+// and MP4 atoms by atom name, or by name for custom (----) atoms.
+// MP4 sort name atoms (soar, soaa) are not read by the tag library, so MP4 has no sort names.
+var (
+	originalDateTags = []string{
+		"originaldate", "originalyear", // vorbis, mp4
+		"tdor", "tory", "tor", "txxx:originaldate", "txxx:originalyear", // id3
+	}
+	labelTags         = []string{"label", "organization", "publisher", "tpub", "tpb", "txxx:label"}
+	catalogNumberTags = []string{"catalognumber", "txxx:catalognumber"}
+	isrcTags          = []string{"isrc", "tsrc", "trc"}
+	bpmTags           = []string{"bpm", "tbpm", "tbp", "tmpo"}
+	compilationTags   = []string{"compilation", "tcmp", "tcp", "cpil", "txxx:compilation"}
+	discSubtitleTags  = []string{"discsubtitle", "setsubtitle", "tsst", "txxx:discsubtitle"}
+	// vorbis disc numbers and totals the tag library does not read, ex. DISCNUMBER=1/2 or TOTALDISCS=2
+	discTags       = []string{"discnumber"}
+	totalDiscsTags = []string{"disctotal", "totaldiscs"}
+
+	musicBrainzTrackTags = []string{
+		"musicbrainz_trackid", "musicbrainz track id", "ufid:http://musicbrainz.org",
+	}
+	musicBrainzAlbumTags = []string{
+		"musicbrainz_albumid", "musicbrainz album id", "txxx:musicbrainz album id",
+	}
+	musicBrainzArtistTags = []string{
+		"musicbrainz_artistid", "musicbrainz artist id", "txxx:musicbrainz artist id",
+	}
+	musicBrainzAlbumArtistTags = []string{
+		"musicbrainz_albumartistid", "musicbrainz album artist id", "txxx:musicbrainz album artist id",
+	}
+	musicBrainzReleaseGroupTags = []string{
+		"musicbrainz_releasegroupid", "musicbrainz release group id", "txxx:musicbrainz release group id",
+	}
+
+	artistSortTags      = []string{"artistsort", "tsop", "tsp", "txxx:artistsort"}
+	albumArtistSortTags = []string{"albumartistsort", "tso2", "ts2", "txxx:albumartistsort"}
+
+	// MP4 work and movement atoms (\xa9wrk, \xa9mvn, \xa9mvi) are not read by the tag library either.
+	workTags         = []string{"work", "txxx:work"}
+	movementNameTags = []string{"movementname", "mvnm", "txxx:movementname"}
+	movementTags     = []string{"movement", "mvin", "txxx:movement"}
+	conductorTags    = []string{"conductor", "tpe3", "tp3", "txxx:conductor"}
+	orchestraTags    = []string{"orchestra", "ensemble", "txxx:orchestra", "txxx:ensemble"}
+	performerTags    = []string{"performer", "txxx:performer"}
+
+	// ID3 content groups hold the work in classical libraries, but often a grouping in others, ex. "Summer Hits",
+	// so they are only read as the work of songs with a composer or a classical genre.
+	contentGroupTags = []string{"tit1", "tt1"}
+)
+
+// rawTags is the raw metadata of a song as text, keyed by lowercase tag name, see the tag name lists above.
+type rawTags map[string]string
+
+// newRawTags converts raw metadata of any format to text keyed by lowercase tag name.
+// Repeated ID3 frames, which the tag library names like TXXX_0, are keyed by their own description or owner.
+func newRawTags(meta tag.Metadata) rawTags {
+	result := make(rawTags)
+	for name, value := range meta.Raw() {
+		name = strings.ToLower(name)
+		if meta.Format() != tag.VORBIS && meta.Format() != tag.MP4 {
+			if underscore := strings.IndexByte(name, '_'); underscore > 0 {
+				name = name[:underscore]
+			}
+		}
+		switch v := value.(type) {
+		case string:
+			result.put(name, v)
+		case int:
+			result.put(name, strconv.Itoa(v))
+		case *tag.Comm:
+			result.put(name+":"+strings.ToLower(v.Description), v.Text)
+		case *tag.UFID:
+			result.put(name+":"+strings.ToLower(v.Provider), string(v.Identifier))
+		case fmt.Stringer:
+			result.put(name, v.String())
+		case []byte: // frames the tag library does not decode, ex. ID3v2.4 MVNM and MVIN, which are text frames
+			if (name == "mvnm" || name == "mvin") && len(v) > 0 {
+				result.put(name, strings.Join(id3TextValues(v[0], v[1:]), multiValueSeparator))
+			}
+		}
+	}
+	return result
+}
+
+func (r rawTags) put(name, value string) {
+	value = strings.TrimSpace(strings.Trim(value, "\x00"))
+	if len(value) > 0 {
+		r[name] = value
+	}
+}
+
+// first returns the value of the first of the tag names that is present, or empty if none are.
+func (r rawTags) first(names []string) string {
+	for _, name := range names {
+		if value, ok := r[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// firstInt returns the leading number of the first of the tag names that is present, or zero.
+// ex. "128.5" is 128
+func (r rawTags) firstInt(names []string) int {
+	value := r.first(names)
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	result, _ := strconv.Atoi(value[:end])
+	return result
+}
+
+// copyExtendedMetadata copies fields not available from the tag.Metadata interface out of the raw tags.
+func (s *Song) copyExtendedMetadata(meta tag.Metadata) {
+	raw := newRawTags(meta)
+	s.OriginalDate = raw.first(originalDateTags)
+	s.Label = raw.first(labelTags)
+	s.CatalogNumber = raw.first(catalogNumberTags)
+	s.ISRC = raw.first(isrcTags)
+	s.BPM = raw.firstInt(bpmTags)
+	s.Compilation = raw.firstInt(compilationTags) > 0 || strings.EqualFold(raw.first(compilationTags), "true")
+	s.DiscSubtitle = raw.first(discSubtitleTags)
+	if s.Disc == 0 {
+		s.Disc = raw.firstInt(discTags)
+	}
+	if s.TotalDiscs == 0 {
+		s.TotalDiscs = raw.firstInt(totalDiscsTags)
+	}
+	if slash := strings.IndexByte(raw.first(discTags), '/'); s.TotalDiscs == 0 && slash >= 0 {
+		s.TotalDiscs, _ = strconv.Atoi(strings.TrimSpace(raw.first(discTags)[slash+1:]))
+	}
+	s.MusicBrainzTrackID = raw.first(musicBrainzTrackTags)
+	s.MusicBrainzAlbumID = raw.first(musicBrainzAlbumTags)
+	s.MusicBrainzArtistID = raw.first(musicBrainzArtistTags)
+	s.MusicBrainzAlbumArtistID = raw.first(musicBrainzAlbumArtistTags)
+	s.MusicBrainzReleaseGroupID = raw.first(musicBrainzReleaseGroupTags)
+	s.ArtistSort = raw.first(artistSortTags)
+	s.AlbumArtistSort = raw.first(albumArtistSortTags)
+	s.Work = raw.first(workTags)
+	if len(s.Work) == 0 && (len(s.Composer) > 0 || hasClassicalGenre(s)) {
+		s.Work = raw.first(contentGroupTags)
+	}
+	s.MovementName = raw.first(movementNameTags)
+	s.Movement = raw.firstInt(movementTags)
+	s.Conductor = raw.first(conductorTags)
+	s.Orchestra = raw.first(orchestraTags)
+	s.ReplayGain = newReplayGain(raw)
+	s.Performers = nil
+	if performer := raw.first(performerTags); len(performer) > 0 {
+		s.Performers = []string{performer}
+	}
+}
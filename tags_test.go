@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestCopyExtendedMetadataWork(t *testing.T) {
+	tests := []struct {
+		name            string
+		composer, genre string
+		comments        map[string][]string
+		want            string
+	}{
+		{name: "work", comments: map[string][]string{"work": {"Requiem"}, "tit1": {"Favourites"}}, want: "Requiem"},
+		{name: "content group of a pop song", genre: "Pop", comments: map[string][]string{"tit1": {"Summer Hits"}}},
+		{name: "content group with a composer", composer: "Mozart",
+			comments: map[string][]string{"tit1": {"Requiem"}}, want: "Requiem"},
+		{name: "content group with a classical genre", genre: "Classical; Choral",
+			comments: map[string][]string{"tt1": {"Requiem"}}, want: "Requiem"},
+	}
+	for _, test := range tests {
+		song := &Song{Composer: test.composer, Genre: test.genre}
+		song.copyExtendedMetadata(&opusMeta{comments: test.comments})
+		if song.Work != test.want {
+			t.Errorf("%s: got work %q, want %q", test.name, song.Work, test.want)
+		}
+	}
+}
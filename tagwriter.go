@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// padding left after tags when a file has to be copied to make room for them, so that later edits fit in place
+const tagPadding = 4096
+
+// writableTag is the raw tag names of a song field, for FLAC Vorbis comments and MP3 ID3v2.4 frames.
+// The first name is written, and all of them are removed, so that the written tag is the one read.
+// User defined ID3 text frames are named TXXX:description.
+type writableTag struct {
+	vorbis []string
+	id3    []string
+}
+
+// writableTags are the song fields that can be written to files, by json name.
+var writableTags = map[string]writableTag{
+	"title":        {[]string{"TITLE"}, []string{"TIT2"}},
+	"album":        {[]string{"ALBUM"}, []string{"TALB"}},
+	"artist":       {[]string{"ARTIST"}, []string{"TPE1"}},
+	"album_artist": {[]string{"ALBUMARTIST"}, []string{"TPE2"}},
+	"composer":     {[]string{"COMPOSER"}, []string{"TCOM"}},
+	"genre":        {[]string{"GENRE"}, []string{"TCON"}},
+	"date":         {[]string{"DATE", "YEAR"}, []string{"TDRC"}},
+	"original_date": {[]string{"ORIGINALDATE", "ORIGINALYEAR"},
+		[]string{"TDOR", "TXXX:ORIGINALDATE", "TXXX:ORIGINALYEAR"}},
+	"track":                {[]string{"TRACKNUMBER"}, []string{"TRCK"}},
+	"disc":                 {[]string{"DISCNUMBER"}, []string{"TPOS"}},
+	"disc_subtitle":        {[]string{"DISCSUBTITLE", "SETSUBTITLE"}, []string{"TSST", "TXXX:DISCSUBTITLE"}},
+	"label":                {[]string{"LABEL", "ORGANIZATION", "PUBLISHER"}, []string{"TPUB", "TXXX:LABEL"}},
+	"catalog_number":       {[]string{"CATALOGNUMBER"}, []string{"TXXX:CATALOGNUMBER"}},
+	"isrc":                 {[]string{"ISRC"}, []string{"TSRC"}},
+	"bpm":                  {[]string{"BPM"}, []string{"TBPM"}},
+	"compilation":          {[]string{"COMPILATION"}, []string{"TCMP", "TXXX:COMPILATION"}},
+	"artist_sort":          {[]string{"ARTISTSORT"}, []string{"TSOP", "TXXX:ARTISTSORT"}},
+	"album_artist_sort":    {[]string{"ALBUMARTISTSORT"}, []string{"TSO2", "TXXX:ALBUMARTISTSORT"}},
+	"musicbrainz_album_id": {[]string{"MUSICBRAINZ_ALBUMID"}, []string{"TXXX:MusicBrainz Album Id"}},
+	"work":                 {[]string{"WORK"}, []string{"TXXX:WORK"}},
+	"movement_name":        {[]string{"MOVEMENTNAME"}, []string{"MVNM", "TXXX:MOVEMENTNAME"}},
+	"movement":             {[]string{"MOVEMENT"}, []string{"MVIN", "TXXX:MOVEMENT"}},
+	"conductor":            {[]string{"CONDUCTOR"}, []string{"TPE3", "TXXX:CONDUCTOR"}},
+	"orchestra":            {[]string{"ORCHESTRA", "ENSEMBLE"}, []string{"TXXX:ORCHESTRA", "TXXX:ENSEMBLE"}},
+}
+
+// vorbisRepeatTags are Vorbis comments, by song field json name, that are only removed by an edit of the field
+// where they repeat a value of its first vorbis name, see writableTags.
+// The tag library reads a PERFORMER as the artist, before ARTIST, so a PERFORMER that is not an ARTIST is kept,
+// and is still read as the artist after an artist edit.
+var vorbisRepeatTags = map[string][]string{
+	"artist": {"PERFORMER"},
+}
+
+// tagEdit replaces the tags of a song field, see writableTags, with its values, or removes them if it has none.
+type tagEdit struct {
+	field  string
+	values []string
+}
+
+// newTagEdits returns the tag edits of song fields by json name, with nil or empty values removing the tags.
+// Multiple artists, album artists, composers or genres are separated by ;
+func newTagEdits(song *Song, fields map[string]*string) ([]tagEdit, error) {
+	var result []tagEdit
+	check := *song
+	for field, value := range fields {
+		field = strings.ToLower(field)
+		if _, ok := writableTags[field]; !ok {
+			return nil, fmt.Errorf("song field %q can not be written", field)
+		}
+		edit := tagEdit{field: field}
+		if value != nil {
+			if err := setSongField(&check, field, *value); err != nil {
+				return nil, err
+			}
+			switch {
+			case songFieldValues(&check, field) != nil:
+				edit.values = *songFieldValues(&check, field)
+			case field == "compilation" && check.Compilation:
+				edit.values = []string{"1"}
+			case field != "compilation" && len(strings.TrimSpace(*value)) > 0:
+				edit.values = []string{strings.TrimSpace(*value)}
+			}
+		}
+		result = append(result, edit)
+	}
+	return result, nil
+}
+
+// withTotal returns the edited values, keeping the total of an old track, disc or movement number, ex. 3/12
+func (e tagEdit) withTotal(old string) []string {
+	if (e.field != "track" && e.field != "disc" && e.field != "movement") || len(e.values) != 1 || strings.Contains(e.values[0], "/") {
+		return e.values
+	}
+	if slash := strings.IndexByte(old, '/'); slash >= 0 {
+		return []string{e.values[0] + old[slash:]}
+	}
+	return e.values
+}
+
+// writeSongTags writes tag edits to the file of a song, without changing its audio, so that its hash stays the same.
+// Only FLAC and MP3 files can be written.
+func writeSongTags(song *Song, edits []tagEdit) error {
+	switch song.FileType {
+	case tag.FLAC:
+		return writeFlacComments(song.Path, edits)
+	case tag.MP3:
+		return writeID3Tag(song.Path, edits)
+	}
+	return fmt.Errorf("tags of %v files can not be written, only FLAC and MP3", song.FileType)
+}
+
+// writeFileAt writes data over the start of a file.
+func writeFileAt(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rewriteFile replaces a file with a copy that starts with head, instead of the bytes before offset.
+// The copy is written next to the file and renamed over it, so that the file is never left half written.
+func rewriteFile(path string, head []byte, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer closeFile(src)
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := ioutil.TempFile(filepath.Dir(path), ".discographic-*")
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		if _, err := dst.Write(head); err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, io.NewSectionReader(src, offset, info.Size()-offset)); err != nil {
+			return err
+		}
+		if err := dst.Chmod(info.Mode()); err != nil {
+			return err
+		}
+		return dst.Sync()
+	}()
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+	}
+	return err
+}
+
+// tagWriter writes tag edits to root library files, and serves the songs read again from the edited files.
+type tagWriter struct {
+	libs       *libraryHolder
+	delimiters []string // split multi-valued tags, see Song.copyMetadata
+}
+
+// write writes tag edits of song fields by json name, see newTagEdits, and returns the song read again,
+// with any metadata overrides.
+func (w *tagWriter) write(hash songHash, fields map[string]*string) (*Song, error) {
+	w.libs.write.Lock()
+	defer w.libs.write.Unlock()
+	current := w.libs.library()
+	song := current.findSong(hash)
+	if song == nil {
+		return nil, fmt.Errorf("couldn't find song for hash: %v", hash)
+	}
+	edits, err := newTagEdits(song, fields)
+	if err != nil {
+		return nil, err
+	}
+	if _, hash, err := readMeta(song.Path); err != nil || hash != song.Hash {
+		return nil, fmt.Errorf("%q changed since it was scanned, scan the library again before writing tags", song.Path)
+	}
+	if err := writeSongTags(song, edits); err != nil {
+		return nil, fmt.Errorf("couldn't write tags of %q: %v", song.Path, err)
+	}
+
+	info, err := os.Stat(song.Path)
+	if err != nil {
+		return nil, err
+	}
+	read, err := readSong(&walkResult{path: song.Path, size: info.Size(), modTime: info.ModTime().UTC()}, w.delimiters)
+	if err != nil || read == nil {
+		return nil, fmt.Errorf("couldn't read %q after writing tags: %v", song.Path, err)
+	}
+	if read.song.Hash != song.Hash {
+		return nil, fmt.Errorf("audio hash of %q changed after writing tags", song.Path)
+	}
+	read.song.Art = song.Art // art is not written
+	lib := current.withSong(read.song).withOverrides(current.metadataOverrides(), w.libs.logger)
+	w.libs.swap(lib)
+	return lib.findSong(hash), nil
+}
+
+// containsFold returns true if the names contain the name, ignoring case.
+func containsFold(names []string, name string) bool {
+	for _, other := range names {
+		if strings.EqualFold(other, name) {
+			return true
+		}
+	}
+	return false
+}
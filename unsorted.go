@@ -6,22 +6,56 @@ type artistAlbum map[string]unsorted
 
 type unsorted map[string][]*Song
 
-func (aa artistAlbum) addAll(lib Library) error {
-	return lib.songs(func(song *Song) error {
-		var artistKey string
-		if len(song.AlbumArtist) > 0 {
-			artistKey = strings.ToLower(song.AlbumArtist)
+// addAll adds each album, grouped by album key, see songAlbumKey, under each of its album artists, see albumArtists.
+// Compilations, see isCompilation, are added under the various artists name instead.
+// Each song is also added to appearsOn under each of its own artists that the album is not added under,
+// ex. the featured artist of a song.
+// Artists are keyed by artistKey, after renaming by any alias.
+func (aa artistAlbum) addAll(albums map[string][]*Song, args collectionArgs, appearsOn artistAlbum) {
+	for albumKey, songs := range albums {
+		added := make(map[string]bool)
+		if isCompilation(songs) {
+			added[artistKey(args.variousArtists)] = true
 		} else {
-			artistKey = strings.ToLower(song.Artist)
+			for _, artist := range albumArtists(songs) {
+				added[args.aliases.key(artist)] = true
+			}
 		}
-		sameArtist, ok := aa[artistKey]
-		if !ok {
-			sameArtist = make(unsorted)
+		for key := range added {
+			aa.add(key, albumKey, songs...)
 		}
-		albumKey := strings.ToLower(song.Album)
-		sameAlbum := sameArtist[albumKey]
-		sameArtist[albumKey] = append(sameAlbum, song)
-		aa[artistKey] = sameArtist
-		return nil
-	})
+		for _, song := range songs {
+			artists := song.Artists
+			if len(artists) == 0 && len(song.Artist) > 0 {
+				artists = []string{song.Artist}
+			}
+			for _, artist := range artists {
+				key := args.aliases.key(artist)
+				if added[key] {
+					continue
+				}
+				appearsOn.add(key, albumKey, song)
+				if _, ok := aa[key]; !ok {
+					aa[key] = make(unsorted)
+				}
+			}
+		}
+	}
+}
+
+func (aa artistAlbum) add(artistKey, albumKey string, songs ...*Song) {
+	sameArtist, ok := aa[artistKey]
+	if !ok {
+		sameArtist = make(unsorted)
+	}
+	sameArtist[albumKey] = append(sameArtist[albumKey], songs...)
+	aa[artistKey] = sameArtist
+}
+
+// songArtistKey returns the key used to group songs of an album by all of its artists together.
+func songArtistKey(song *Song) string {
+	if len(song.AlbumArtist) > 0 {
+		return strings.ToLower(song.AlbumArtist)
+	}
+	return strings.ToLower(song.Artist)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestArtistAlbumAddAll(t *testing.T) {
+	song := func(title, artist, albumArtist string, compilation bool) *Song {
+		return &Song{
+			Title:        title,
+			Artist:       artist,
+			Artists:      splitTagValues([]string{artist}, parseTagDelimiters(defaultTagDelimiters)),
+			AlbumArtist:  albumArtist,
+			AlbumArtists: splitTagValues([]string{albumArtist}, nil),
+			Compilation:  compilation,
+		}
+	}
+	albums := map[string][]*Song{
+		"blueprint": {
+			song("J1", "Jay-Z", "", false),
+			song("J2", "Jay-Z feat. Eminem", "", false),
+		},
+		"together": {
+			song("T1", "Jay-Z; Beyoncé", "", false),
+			song("T2", "Beyoncé; Jay-Z", "", false),
+		},
+		"lemonade": {
+			song("L1", "Beyoncé", "Beyoncé", false),
+			song("L2", "Beyoncé feat. Jack White", "Beyoncé", false),
+		},
+		"now": {
+			song("N1", "Muse", "", true),
+			song("N2", "Solo Act feat. Beyoncé", "", true),
+		},
+	}
+	aa, appearsOn := make(artistAlbum), make(artistAlbum)
+	aa.addAll(albums, collectionArgs{variousArtists: "Various Artists"}, appearsOn)
+
+	titles := func(songs []*Song) string {
+		var result []string
+		for _, song := range songs {
+			result = append(result, song.Title)
+		}
+		sort.Strings(result)
+		return strings.Join(result, ",")
+	}
+	tests := []struct {
+		artist    string
+		albums    []string
+		appearsOn map[string]string // album key to song titles
+	}{
+		{artist: "Jay-Z", albums: []string{"blueprint", "together"}},
+		{artist: "Eminem", appearsOn: map[string]string{"blueprint": "J2"}},
+		{artist: "Beyoncé", albums: []string{"lemonade", "together"}, appearsOn: map[string]string{"now": "N2"}},
+		{artist: "Jack White", appearsOn: map[string]string{"lemonade": "L2"}},
+		{artist: "Various Artists", albums: []string{"now"}},
+		{artist: "Muse", appearsOn: map[string]string{"now": "N1"}},
+		{artist: "Solo Act", appearsOn: map[string]string{"now": "N2"}},
+	}
+	for _, test := range tests {
+		key := artistKey(test.artist)
+		albums, ok := aa[key]
+		if !ok {
+			t.Errorf("%q is not an artist", test.artist)
+			continue
+		}
+		var got []string
+		for album := range albums {
+			got = append(got, album)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(test.albums, ",") {
+			t.Errorf("%q albums = %v, want %v", test.artist, got, test.albums)
+		}
+		if len(appearsOn[key]) != len(test.appearsOn) {
+			t.Errorf("%q appears on %v albums, want %v", test.artist, len(appearsOn[key]), len(test.appearsOn))
+		}
+		for album, want := range test.appearsOn {
+			if got := titles(appearsOn[key][album]); got != want {
+				t.Errorf("%q appears on %q with %q, want %q", test.artist, album, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/shawnsmithdev/tag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// max difference between the duration of an opus file and the FLAC file it was encoded from
+const opusDurationTolerance = 500 * time.Millisecond
+
+// errMissing is returned by verifyMobileFile when the mobile file does not exist.
+var errMissing = fmt.Errorf("missing")
+
+// verifyMobile checks that the files wanted in the mobile library are present and intact.
+// Copied songs are hashed and compared with the root library, opus files are checked for bad pages,
+// decoded if opusdec is available, and compared with the duration of the FLAC file, and other files
+// are compared byte for byte.
+// If repair is true, bad files are deleted and the mobile library is synced again.
+// If ctx is canceled, verification stops and the context error is returned.
+func verifyMobile(ctx context.Context, args mobileSyncArgs, repair bool) error {
+	plan, err := planMobile(args)
+	if err != nil {
+		return err
+	}
+	verifyProgress := args.progress.start("mobile verify")
+	for _, mf := range plan.wanted {
+		verifyProgress.addTotal(1, mf.size)
+	}
+
+	var (
+		bad     []string
+		missing int
+	)
+	for _, key := range plan.keys() {
+		if err := ctx.Err(); err != nil {
+			verifyProgress.finish()
+			return err
+		}
+		mf := plan.wanted[key]
+		path := args.mobile + mf.target
+		if err := verifyMobileFile(plan, mf, path); err == errMissing {
+			args.logger.Printf("missing: %q", path)
+			missing++
+		} else if err != nil {
+			args.logger.Printf("failed verification: %q: %v", path, err)
+			bad = append(bad, path)
+		}
+		verifyProgress.addDone(1, mf.size)
+	}
+	verifyProgress.finish()
+	args.logger.Printf("verified %v mobile files, %v bad, %v missing", len(plan.wanted), len(bad), missing)
+
+	if len(bad) == 0 && missing == 0 {
+		return nil
+	}
+	if !repair {
+		return fmt.Errorf("mobile library has %v bad and %v missing files", len(bad), missing)
+	}
+	for _, path := range bad {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		args.logger.Printf("deleted bad file: %q", path)
+	}
+	return syncMobile(ctx, args)
+}
+
+func verifyMobileFile(plan *mobilePlan, mf *mobileFile, path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return errMissing
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case mf.generate != nil:
+		expected, err := mf.generate()
+		if err != nil {
+			return err
+		}
+		return compareFile(path, expected)
+	case mf.fileType == string(tag.FLAC):
+		return verifyOpus(path, plan.args.root+mf.rootPath)
+	case mf.song != nil:
+		meta, hash, err := readMeta(path)
+		if err != nil {
+			return err
+		}
+		if meta == nil {
+			return fmt.Errorf("not a readable audio file")
+		}
+		if hash != mf.song.Hash {
+			return fmt.Errorf("audio hash %v does not match root library hash %v", hash, mf.song.Hash)
+		}
+		return nil
+	default:
+		expected, err := ioutil.ReadFile(plan.args.root + mf.rootPath)
+		if err != nil {
+			return err
+		}
+		return compareFile(path, expected)
+	}
+}
+
+func compareFile(path string, expected []byte) error {
+	actual, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, expected) {
+		return fmt.Errorf("content does not match (size %v, expected %v)", len(actual), len(expected))
+	}
+	return nil
+}
+
+// verifyOpus checks an opus file for bad pages, that it decodes if opusdec is available,
+// and that its duration matches the FLAC file it was encoded from.
+func verifyOpus(path string, flacPath string) error {
+	duration, err := opusDuration(path)
+	if err != nil {
+		return err
+	}
+	if opusdec, err := exec.LookPath("opusdec"); err == nil {
+		if out, err := exec.Command(opusdec, "--quiet", path, os.DevNull).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to decode: %v: %s", err, bytes.TrimSpace(out))
+		}
+	}
+	expected, err := flacDuration(flacPath)
+	if err != nil {
+		return err
+	}
+	if diff := duration - expected; diff > opusDurationTolerance || diff < -opusDurationTolerance {
+		return fmt.Errorf("duration %v does not match FLAC duration %v", duration, expected)
+	}
+	return nil
+}
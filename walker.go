@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"github.com/shawnsmithdev/tag"
 	"golang.org/x/sync/errgroup"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"time"
@@ -29,10 +31,12 @@ func walker(out chan *walkResult) filepath.WalkFunc {
 	}
 }
 
-func runWalker(root string, paths chan *walkResult) {
+// runWalker sends every file under root to paths, closing paths when done.
+// Returns the first error walking the files, after which no more files are sent.
+func runWalker(root string, paths chan *walkResult) error {
 	defer close(paths)
 	// TODO: Check that this is a folder first
-	forbidErr(filepath.Walk(root, walker(paths)))
+	return filepath.Walk(root, walker(paths))
 }
 
 func readMeta(path string) (tag.Metadata, songHash, error) {
@@ -48,7 +52,16 @@ func readMeta(path string) (tag.Metadata, songHash, error) {
 		return nil, hash, err
 	}
 	if meta, err = tag.ReadFrom(songFile); err != nil {
-		return nil, hash, nil // not an audio file, or otherwise unreadable
+		// the tag library reads ogg vorbis but not ogg opus
+		if _, err = songFile.Seek(0, 0); err != nil {
+			return nil, hash, err
+		}
+		opus, opusHash, opusErr := readOpusMeta(songFile)
+		if opusErr != nil {
+			closeFile(songFile)
+			return nil, hash, nil // not an audio file, or otherwise unreadable
+		}
+		return opus, opusHash, songFile.Close()
 	}
 	if _, err = songFile.Seek(0, 0); err != nil {
 		return nil, hash, err
@@ -57,6 +70,10 @@ func readMeta(path string) (tag.Metadata, songHash, error) {
 		if hash, err = flacMd5(songFile); err == nil {
 			foundHash = true
 		}
+	} else if meta.FileType() == tag.MP3 {
+		if hash, err = id3AudioSum(songFile); err == nil {
+			foundHash = true
+		}
 	}
 	if !foundHash {
 		if tagHash, err = tag.Sum(songFile); err != nil {
@@ -75,81 +92,141 @@ type songAndArt struct {
 	art  *Art
 }
 
-func handleSongWalk(wr *walkResult, out chan songAndArt) error {
-	meta, hash, err := readMeta(wr.path)
-	if err == nil {
-		if meta == nil { // probably not a song
-			return nil
-		}
-		hash64 := hash.String()
-		file := hash64
-		ext := filepath.Ext(wr.path)
-		if len(ext) > 0 {
-			file += ext
-		}
+func handleSongWalk(wr *walkResult, delimiters []string, out chan songAndArt) error {
+	result, err := readSong(wr, delimiters)
+	if err != nil {
+		log.Println("meta error", err)
+		return err
+	}
+	if result != nil {
+		out <- *result
+	}
+	return nil
+}
 
-		// song
-		song := &Song{
-			File:     file,
-			MetaFile: hash64 + ".json",
-			Size:     wr.size,
-			ModTime:  wr.modTime,
-			Path:     wr.path,
-			Hash:     hash,
-		}
-		song.copyMetadata(meta)
+// readSong reads a song and its art from a file, or returns nil if it is probably not a song.
+// Multi-valued tags are split by the delimiters, see Song.copyMetadata.
+func readSong(wr *walkResult, delimiters []string) (*songAndArt, error) {
+	meta, hash, err := readMeta(wr.path)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	hash64 := hash.String()
+	file := hash64
+	ext := filepath.Ext(wr.path)
+	if len(ext) > 0 {
+		file += ext
+	}
 
-		// art
-		pic := meta.Picture()
-		var songArt *Art
-		if pic != nil {
-			songArt = &Art{
-				Data:     pic.Data,
-				Ext:      pic.Ext,
-				MimeType: pic.MIMEType,
-			}
-		}
+	// song
+	song := &Song{
+		File:     file,
+		MetaFile: hash64 + ".json",
+		Size:     wr.size,
+		ModTime:  wr.modTime,
+		Path:     wr.path,
+		Hash:     hash,
+	}
+	song.copyMetadata(meta, delimiters)
+	if duration, err := songDuration(wr.path, song.FileType); err == nil {
+		song.Duration = math.Round(duration.Seconds()*1000) / 1000
+	}
 
-		out <- songAndArt{
-			song: song,
-			art:  songArt,
+	// art
+	pic := meta.Picture()
+	var songArt *Art
+	if pic != nil {
+		songArt = &Art{
+			Data:     pic.Data,
+			Ext:      pic.Ext,
+			MimeType: pic.MIMEType,
 		}
-	} else {
-		log.Println("meta error", err)
 	}
-	return err
+
+	return &songAndArt{
+		song: song,
+		art:  songArt,
+	}, nil
 }
 
-func runSongWalkers(root string, parallel int) chan songAndArt {
+// runSongWalkers reads songs from all files under root.
+// All files are found before any are read, so that progress has a known total.
+// Known songs are reused instead of read again, if the file size and modified time have not changed.
+// If ctx is canceled, no more files are read.
+// Multi-valued tags are split by the delimiters, see Song.copyMetadata.
+// The first error finding or reading files stops the walk, and is sent to the returned error channel
+// after the song channel is closed. Nil is sent if there was no error.
+func runSongWalkers(ctx context.Context, root string, parallel int, known map[string]songAndArt, delimiters []string,
+	p *progress) (chan songAndArt, chan error) {
+	eg, ctx := errgroup.WithContext(ctx)
 	paths := make(chan *walkResult, parallel*16)
-	go runWalker(root, paths)
+	eg.Go(func() error {
+		defer close(paths)
+		p.setPhase("finding files")
+		walked := make(chan *walkResult, parallel*16)
+		walkErr := make(chan error, 1)
+		go func() {
+			walkErr <- runWalker(root, walked)
+		}()
+		var all []*walkResult
+		for wr := range walked {
+			all = append(all, wr)
+			p.addTotal(1, wr.size)
+		}
+		if err := <-walkErr; err != nil {
+			return err
+		}
+		p.setPhase("reading songs")
+		for _, wr := range all {
+			select {
+			case paths <- wr:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
 
 	out := make(chan songAndArt, parallel*2)
-	go func() {
-		defer close(out)
-		var eg errgroup.Group
-		for i := 0; i < parallel; i++ {
-			eg.Go(func() error {
-				for result := range paths {
-					if err := handleSongWalk(result, out); err != nil {
-						return err
-					}
+	for i := 0; i < parallel; i++ {
+		eg.Go(func() error {
+			for result := range paths {
+				if ctx.Err() != nil {
+					continue // drain
 				}
-				return nil
-			})
-		}
-		forbidErr(eg.Wait())
+				if song, ok := known[result.path]; ok &&
+					song.song.Size == result.size && song.song.ModTime.Equal(result.modTime) {
+					out <- song
+					p.addDone(1, result.size)
+					continue
+				}
+				if err := handleSongWalk(result, delimiters, out); err != nil {
+					return err
+				}
+				p.addDone(1, result.size)
+			}
+			return nil
+		})
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- eg.Wait()
+		close(out)
 	}()
-	return out
+	return out, errs
 }
 
-func runPathWalkers(mobile string) map[string]struct{} {
+// runPathWalkers returns the paths of all files under mobile.
+func runPathWalkers(mobile string) (map[string]struct{}, error) {
 	paths := make(chan *walkResult, 256)
-	go runWalker(mobile, paths)
+	walkErr := make(chan error, 1)
+	go func() {
+		walkErr <- runWalker(mobile, paths)
+	}()
 	result := make(map[string]struct{})
 	var nothing struct{}
 	for wr := range paths {
 		result[wr.path] = nothing
 	}
-	return result
+	return result, <-walkErr
 }